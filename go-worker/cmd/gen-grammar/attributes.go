@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type AttributeValue struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Handle string `json:"handle"`
+}
+
+type Attribute struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Handle string            `json:"handle"`
+	Values []*AttributeValue `json:"values"`
+}
+
+type AttributesRoot struct {
+	Attributes []*Attribute `json:"attributes"`
+}
+
+// CategoryAttribute references an attribute from a category in taxonomy.json
+type CategoryAttribute struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Handle string `json:"handle"`
+}
+
+// attributeGrammar collects the attribute rules needed by the taxonomy.
+// Categories with the same attribute set share one end rule, and each
+// attribute's value list is only emitted once.
+type attributeGrammar struct {
+	byHandle map[string]*Attribute
+	used     []*Attribute
+	usedSet  map[string]bool
+	endRules map[string]string
+	setRules string
+	unknown  map[string]bool
+}
+
+func newAttributeGrammar(attributes []*Attribute) *attributeGrammar {
+	byHandle := make(map[string]*Attribute, len(attributes))
+	for _, attribute := range attributes {
+		if len(attribute.Values) > 0 {
+			byHandle[attribute.Handle] = attribute
+		}
+	}
+
+	return &attributeGrammar{
+		byHandle: byHandle,
+		usedSet:  make(map[string]bool),
+		endRules: make(map[string]string),
+		unknown:  make(map[string]bool),
+	}
+}
+
+// endRule returns the rule that closes the taxonomy string and emits the
+// "attributes" object for the category
+func (g *attributeGrammar) endRule(category *Category) string {
+	var handles []string
+	for _, ref := range category.Attributes {
+		if _, ok := g.byHandle[ref.Handle]; !ok {
+			// Extended attributes and attributes without fixed values can't be constrained
+			g.unknown[ref.Handle] = true
+			continue
+		}
+		handles = append(handles, ref.Handle)
+	}
+
+	signature := strings.Join(handles, ",")
+	if ruleName, ok := g.endRules[signature]; ok {
+		return ruleName
+	}
+
+	ruleName := fmt.Sprintf("taxonomy-end-%d", len(g.endRules))
+	g.endRules[signature] = ruleName
+
+	var keys []string
+	for _, handle := range handles {
+		keys = append(keys, "\"\\\""+handle+"\\\":\" ws attr-"+clean(handle))
+		if !g.usedSet[handle] {
+			g.usedSet[handle] = true
+			g.used = append(g.used, g.byHandle[handle])
+		}
+	}
+
+	g.setRules += ruleName + " ::= \"\\\"\" ws \",\" ws \"\\\"attributes\\\":\" ws \"{\" ws "
+	if len(keys) > 0 {
+		g.setRules += strings.Join(keys, " ws \",\" ws ") + " ws "
+	}
+	g.setRules += "\"}\"\n"
+
+	return ruleName
+}
+
+// rules returns the end rules followed by the value list of every
+// attribute they reference. Each value is a list (possibly empty) so the
+// model can skip attributes it can't see.
+func (g *attributeGrammar) rules() string {
+	if len(g.unknown) > 0 {
+		fmt.Printf("Skipped %d attributes without a fixed value list\n", len(g.unknown))
+	}
+	fmt.Printf("Generated %d attribute sets using %d attributes\n", len(g.endRules), len(g.used))
+
+	rules := g.setRules + "\n"
+	for _, attribute := range g.used {
+		ruleName := "attr-" + clean(attribute.Handle)
+		rules += ruleName + " ::= \"[\" ws (" + ruleName + "-value (ws \",\" ws " + ruleName + "-value)*)? ws \"]\"\n"
+		rules += ruleName + "-value ::= (\n"
+		for i, value := range attribute.Values {
+			rules += "\t" + jsonStringLiteral(value.Name)
+			if i < len(attribute.Values)-1 {
+				rules += " | \n"
+			}
+		}
+		rules += "\n)\n\n"
+	}
+	return rules
+}
+
+// jsonStringLiteral returns a GBNF literal that matches s as a JSON string
+func jsonStringLiteral(s string) string {
+	// Don't HTML-escape, or "&" would have to be generated as "\u0026"
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	encoded := strings.TrimSuffix(buf.String(), "\n")
+
+	escaped := strings.ReplaceAll(encoded, "\\", "\\\\")
+	escaped = strings.ReplaceAll(escaped, "\"", "\\\"")
+	return "\"" + escaped + "\""
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,10 +17,11 @@ type Child struct {
 }
 
 type Category struct {
-	ID       string   `json:"id"`
-	Name     string   `json:"name"`
-	Children []*Child `json:"children,omitempty"`
-	Level    int      `json:"level"`
+	ID         string               `json:"id"`
+	Name       string               `json:"name"`
+	Children   []*Child             `json:"children,omitempty"`
+	Attributes []*CategoryAttribute `json:"attributes,omitempty"`
+	Level      int                  `json:"level"`
 }
 
 type BaseCategory struct {
@@ -31,86 +33,205 @@ type Root struct {
 	Verticals []*BaseCategory `json:"verticals"`
 }
 
+const distURL = "https://raw.githubusercontent.com/Shopify/product-taxonomy/refs/heads/main/dist"
+
 func main() {
-	fmt.Println("Fetching latest taxonomy from Shopify...")
-	resp, err := http.Get("https://raw.githubusercontent.com/Shopify/product-taxonomy/refs/heads/main/dist/en/taxonomy.json")
+	locales := flag.String("locales", "fr,de", "Comma-separated output locales to build localized taxonomy grammars for")
+	flag.Parse()
 
-	if err != nil {
+	fmt.Println("Fetching latest taxonomy from Shopify...")
+	var root Root
+	if err := fetchJSON(distURL+"/en/taxonomy.json", &root); err != nil {
 		fmt.Printf("Error fetching taxonomy: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	// Only using apparel vertical
+	apparel, err := findBaseCategory(&root, "Apparel & Accessories")
 	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
+		fmt.Printf("Error finding base category: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Found %d categories in apparel vertical\n", len(apparel.Categories))
+
+	fmt.Println("Fetching latest attributes from Shopify...")
+	var attributesRoot AttributesRoot
+	if err := fetchJSON(distURL+"/en/attributes.json", &attributesRoot); err != nil {
+		fmt.Printf("Error fetching attributes: %v\n", err)
 		return
 	}
 
+	attributes := newAttributeGrammar(attributesRoot.Attributes)
+
+	var taxonomyRules string
+	for _, category := range apparel.Categories {
+		taxonomyRules += generateRule(category, attributes.endRule(category))
+	}
+	taxonomyRules = attributes.rules() + taxonomyRules
+
+	writeGrammar("../docs/taxonomy.gbnf", singleProductHeader+taxonomyRules)
+	writeGrammar("../docs/taxonomy-multi.gbnf", multiProductHeader+taxonomyRules)
+
+	for _, locale := range strings.Split(*locales, ",") {
+		locale = strings.TrimSpace(locale)
+		if locale == "" || locale == "en" {
+			continue
+		}
+		generateLocaleGrammar(locale, apparel.Categories[0].ID)
+	}
+}
+
+// generateLocaleGrammar writes a grammar for translating a result into the
+// locale, with taxonomy paths built from the localized category names.
+// Verticals are matched by root category ID since their names are translated.
+func generateLocaleGrammar(locale string, rootCategoryID string) {
+	fmt.Printf("Fetching %s taxonomy from Shopify...\n", locale)
 	var root Root
-	err = json.Unmarshal(body, &root)
-	if err != nil {
-		fmt.Printf("Error unmarshalling taxonomy: %v\n", err)
+	if err := fetchJSON(distURL+"/"+locale+"/taxonomy.json", &root); err != nil {
+		fmt.Printf("Error fetching %s taxonomy: %v\n", locale, err)
 		return
 	}
 
-	// Only using apparel vertical
-	apparel, err := findBaseCategory(&root, "Apparel & Accessories")
+	vertical, err := findBaseCategoryByRootID(&root, rootCategoryID)
 	if err != nil {
-		fmt.Printf("Error finding base category: %v\n", err)
+		fmt.Printf("Error finding %s base category: %v\n", locale, err)
 		return
 	}
 
-	fmt.Printf("Found %d categories in apparel vertical\n", len(apparel.Categories))
+	var taxonomyRules string
+	for _, category := range vertical.Categories {
+		taxonomyRules += generateRule(category, "taxonomy-end")
+	}
+
+	writeGrammar("../docs/taxonomy-"+locale+".gbnf", localeHeader+taxonomyRules)
+}
 
-	gbnf := `root ::= "{" ws "\"title\":" ws string ws "," ws "\"description\":" ws string ws "," ws "\"taxonomy\":" ws taxonomy ws "}"
-ws ::= [ \t\n\r]*
+// Policy checks, emitted for the whole image in both analysis modes
+const violationRules = `violations ::= "{" ws "\"prohibited_item\":" ws flag ws "," ws "\"adult_content\":" ws flag ws "," ws "\"weapons\":" ws flag ws "," ws "\"third_party_logo\":" ws flag ws "," ws "\"text_watermark\":" ws flag ws "}"
+flag ::= "{" ws "\"flagged\":" ws boolean ws "," ws "\"reason\":" ws string ws "}"
+boolean ::= "true" | "false"
+`
+
+// Shared rules used by every grammar variant
+const commonRules = `ws ::= [ \t\n\r]*
 string ::= "\"" char* "\""
 char ::= [^"\\] | "\\" ["\\/bfnrt]
 seperator ::= " > "
-taxonomy ::= "\"" taxonomy-inner "\""
+taxonomy ::= "\"" taxonomy-inner
 `
 
-	for _, category := range apparel.Categories {
-		gbnf += generateRule(category)
+// Default mode: a single main product per request, with an optional
+// bounding box around it
+const singleProductHeader = `root ::= "{" ws "\"title\":" ws string ws "," ws "\"description\":" ws string ws "," ws "\"taxonomy\":" ws taxonomy ws "," ws seo ws "," ws "\"violations\":" ws violations ws ("," ws "\"bbox\":" ws bbox ws)? "}"
+` + seoRules + violationRules + bboxRules + commonRules
+
+// SEO fields: up to 10 short search tags, alt text, a meta description
+// within the 160 character search snippet budget and a URL handle slug
+const seoRules = `seo ::= "\"tags\":" ws tags ws "," ws "\"alt_text\":" ws string ws "," ws "\"meta_description\":" ws meta-description ws "," ws "\"handle\":" ws handle
+tags ::= "[" ws tag (ws "," ws tag){0,9} ws "]"
+tag ::= "\"" char{1,40} "\""
+meta-description ::= "\"" char{1,160} "\""
+handle ::= "\"" [a-z0-9]+ ("-" [a-z0-9]+)* "\""
+`
+
+// Multi-product mode: every distinct product in the image with a rough
+// bounding box as [x1, y1, x2, y2] in 0-1000 relative coordinates
+const multiProductHeader = `root ::= "{" ws "\"items\":" ws "[" ws item (ws "," ws item)* ws "]" ws "," ws "\"violations\":" ws violations ws "}"
+item ::= "{" ws "\"title\":" ws string ws "," ws "\"taxonomy\":" ws taxonomy ws "," ws "\"bbox\":" ws bbox ws "}"
+` + violationRules + bboxRules + commonRules
+
+// Bounding boxes as [x1, y1, x2, y2] in 0-1000 relative coordinates
+const bboxRules = `bbox ::= "[" ws coord ws "," ws coord ws "," ws coord ws "," ws coord ws "]"
+coord ::= [0-9] | [1-9] [0-9] | [1-9] [0-9] [0-9] | "1000"
+`
+
+// Localized output: translated title and description plus the taxonomy
+// path in the locale's category names. Attributes are not localized.
+const localeHeader = `root ::= "{" ws "\"title\":" ws string ws "," ws "\"description\":" ws string ws "," ws "\"taxonomy\":" ws taxonomy ws "}"
+taxonomy-end ::= "\""
+` + commonRules
+
+func writeGrammar(path string, gbnf string) {
+	if err := os.WriteFile(path, []byte(gbnf), 0644); err != nil {
+		fmt.Printf("Error writing %s: %v\n", path, err)
+		return
 	}
-	os.WriteFile("../docs/taxonomy.gbnf", []byte(gbnf), 0644)
-	fmt.Println("Taxonomy grammar file written to ../docs/taxonomy.gbnf")
+	fmt.Printf("Taxonomy grammar file written to %s\n", path)
 }
 
-func generateRule(category *Category) string {
-	gbnfOutput := "taxonomy"
-
+// generateRule emits the rule for one category. Wherever the path may stop,
+// endRule closes the taxonomy string and emits the attribute object for
+// this category, so only its own attributes can follow "taxonomy".
+func generateRule(category *Category, endRule string) string {
+	name := "taxonomy-inner"
 	if category.Level > 0 {
-		gbnfOutput += "-" + clean(category.Name)
-	}else {
-		gbnfOutput += "-inner"
+		name = ruleName(category.ID)
 	}
 
-	gbnfOutput += " ::= \"" + category.Name + "\" "
+	gbnfOutput := name + " ::= \"" + category.Name + "\" "
 
 	if len(category.Children) > 0 {
-		gbnfOutput += "(seperator taxonomy-" + clean(category.Name) + "-children)"
-		if (category.Level > 0) {
-			gbnfOutput += "? \n\n"
-		}else {
-			gbnfOutput += "\n\n"
+		gbnfOutput += "(seperator " + name + "-children"
+		if category.Level > 0 {
+			gbnfOutput += " | " + endRule + ") \n\n"
+		} else {
+			gbnfOutput += ")\n\n"
 		}
-		gbnfOutput += "taxonomy-" + clean(category.Name) + "-children ::= (\n"
-		for _, child := range category.Children {
-			gbnfOutput += "\ttaxonomy-" + clean(child.Name)
-			if child != category.Children[len(category.Children)-1] {
+		gbnfOutput += name + "-children ::= (\n"
+		for i, child := range category.Children {
+			gbnfOutput += "\t" + ruleName(child.ID)
+			if i < len(category.Children)-1 {
 				gbnfOutput += " | \n"
 			}
 		}
 		gbnfOutput += "\n)\n\n"
 	} else {
-		gbnfOutput += "\n\n"
+		gbnfOutput += endRule + "\n\n"
 	}
 
 	return gbnfOutput
 }
 
+// ruleName names a category's rule after its ID (e.g. "aa-1-13-8" from
+// gid://shopify/TaxonomyCategory/aa-1-13-8). Names can't be used: they
+// repeat across branches ("Hoodies" under several parents, each with its
+// own attributes) and localized names aren't ASCII.
+func ruleName(id string) string {
+	return "taxonomy-" + clean(id[strings.LastIndex(id, "/")+1:])
+}
+
+func fetchJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", url, err)
+	}
+	return nil
+}
+
+func findBaseCategoryByRootID(root *Root, rootCategoryID string) (*BaseCategory, error) {
+	for _, vertical := range root.Verticals {
+		if len(vertical.Categories) > 0 && vertical.Categories[0].ID == rootCategoryID {
+			return vertical, nil
+		}
+	}
+	return nil, fmt.Errorf("base category not found: %s", rootCategoryID)
+}
+
 func findBaseCategory(root *Root, categoryID string) (*BaseCategory, error) {
 	for _, vertical := range root.Verticals {
 		if vertical.Name == categoryID {
@@ -123,11 +244,11 @@ func findBaseCategory(root *Root, categoryID string) (*BaseCategory, error) {
 func clean(s string) string {
 	var b strings.Builder
 	for _, r := range s {
-		if  (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
 			b.WriteRune(r)
-		}else if r >= 'A' && r <= 'Z' {
+		} else if r >= 'A' && r <= 'Z' {
 			b.WriteRune(unicode.ToLower(r))
-		}else if (b.String()[len(b.String())-1] != '-') {
+		} else if b.Len() == 0 || !strings.HasSuffix(b.String(), "-") {
 			b.WriteRune('-')
 		}
 	}
@@ -12,19 +12,37 @@ import (
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/ai"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/cache"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/copypolicy"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/db"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/image"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/queue"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/storage"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Model             string `yaml:"model"`
-	Taxonomy          string `yaml:"taxonomy"`
-	Database          string `yaml:"database"`
-	LocalAcceleration string `yaml:"local_acceleration"`  // metal, gpu, cpu, arm
-	LocalGPULayers    int    `yaml:"local_gpu_layers"`    // GPU layers for local
-	DockerAcceleration string `yaml:"docker_acceleration"` // metal, gpu, cpu, arm
-	DockerGPULayers    int    `yaml:"docker_gpu_layers"`   // GPU layers for Docker
+	Model              string                        `yaml:"model"`
+	Taxonomy           string                        `yaml:"taxonomy"`
+	MultiTaxonomy      string                        `yaml:"multi_taxonomy"` // grammar for multi-product mode
+	Locales            []string                      `yaml:"locales"`        // default output locales, e.g. fr, de
+	Database           string                        `yaml:"database"`
+	DatabasePool       db.Config                     `yaml:"database_pool"`
+	LocalAcceleration  string                        `yaml:"local_acceleration"`  // metal, gpu, cpu, arm
+	LocalGPULayers     int                           `yaml:"local_gpu_layers"`    // GPU layers for local
+	DockerAcceleration string                        `yaml:"docker_acceleration"` // metal, gpu, cpu, arm
+	DockerGPULayers    int                           `yaml:"docker_gpu_layers"`   // GPU layers for Docker
+	CopyPolicies       map[string]*copypolicy.Policy `yaml:"copy_policies"`       // per merchant, "default" as fallback
+	NearDuplicates     queue.NearDuplicateConfig     `yaml:"near_duplicates"`
+	ResultCache        cache.Config                  `yaml:"result_cache"`
+	Resize             image.ResizePolicy            `yaml:"resize"`
+	Quality            image.QualityPolicy           `yaml:"quality"`
+	Colors             image.ColorPolicy             `yaml:"colors"`
+	Tiles              image.TilePolicy              `yaml:"tiles"`
+	Crops              image.CropPolicy              `yaml:"crops"`
+	InputLimits        image.Limits                  `yaml:"input_limits"`
+	ImageRoots         []string                      `yaml:"image_roots"` // relative to the project root
+	ImageSources       storage.Config                `yaml:"image_sources"`
 }
 
 func findProjectRoot() (string, error) {
@@ -64,11 +82,38 @@ func loadConfig(projectRoot string) (*Config, error) {
 		return nil, err
 	}
 
-	var config Config
+	// Defaults are set before unmarshalling so that settings left out of
+	// the file keep them, while an explicit 0 is kept as 0
+	config := Config{
+		Resize:       image.DefaultResizePolicy(),
+		InputLimits:  image.DefaultLimits(),
+		Quality:      image.DefaultQualityPolicy(),
+		Colors:       image.DefaultColorPolicy(),
+		Tiles:        image.DefaultTilePolicy(),
+		Crops:        image.DefaultCropPolicy(),
+		DatabasePool: db.DefaultConfig(),
+	}
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
 
+	config.Resize.Limits = config.InputLimits
+	if err := config.Resize.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Colors.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Tiles.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Crops.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.DatabasePool.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -139,16 +184,49 @@ func main() {
 	llamaServerPath := filepath.Join(projectRoot, "infra", "llama", "llama-server")
 	modelPath := filepath.Join(projectRoot, "infra", "models", config.Model)
 	grammarPath := filepath.Join(projectRoot, config.Taxonomy)
+	var multiGrammarPath string
+	if config.MultiTaxonomy != "" {
+		multiGrammarPath = filepath.Join(projectRoot, config.MultiTaxonomy)
+	}
+
+	// Locale grammars sit next to the main grammar, e.g. docs/taxonomy-fr.gbnf
+	localeGrammarPaths := make(map[string]string)
+	for _, locale := range config.Locales {
+		localeGrammarPaths[locale] = strings.TrimSuffix(grammarPath, ".gbnf") + "-" + locale + ".gbnf"
+	}
+
+	// Job images may only be read from these directories
+	imageRoots := make([]string, 0, len(config.ImageRoots))
+	for _, root := range config.ImageRoots {
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(projectRoot, root)
+		}
+		imageRoots = append(imageRoots, root)
+	}
+	roots, err := storage.NewRoots(imageRoots)
+	if err != nil {
+		panic(err)
+	}
 
 	// 1. Initialize DB - prefer DATABASE_URL env var, fall back to config.yml
 	databaseURL := os.Getenv("DATABASE_URL")
 	if databaseURL == "" {
 		databaseURL = config.Database
 	}
-	dbConn, err := db.NewConnection(databaseURL)
+	dbConn, err := db.NewConnection(databaseURL, config.DatabasePool)
 	if err != nil {
 		panic(err)
 	}
+	defer dbConn.Close()
+
+	// S3 credentials follow the AWS convention
+	config.ImageSources.S3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	config.ImageSources.S3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	config.ImageSources.S3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		config.ImageSources.S3.Endpoint = endpoint
+	}
+	sources := storage.NewSources(roots, config.ImageSources, config.Resize.Limits.MaxFileBytes, dbConn)
 
 	// 2. Initialize Redis - prefer REDIS_URL env var, fall back to localhost
 	redisURL := os.Getenv("REDIS_URL")
@@ -187,12 +265,22 @@ func main() {
 	rdb := redis.NewClient(rdbOpts)
 
 	// 3. Initialize AI
-	aiEngine, err := ai.NewEngine(llamaServerPath, modelPath, grammarPath, acceleration, gpuLayers)
+	aiEngine, err := ai.NewEngine(llamaServerPath, modelPath, grammarPath, multiGrammarPath, localeGrammarPaths, config.Locales, acceleration, gpuLayers)
 	if err != nil {
 		panic(err)
 	}
 	defer aiEngine.Close()
 
 	// 4. Start Blocking Worker
-	queue.StartWorker(rdb, aiEngine, dbConn)
+	queue.StartWorker(rdb, aiEngine, dbConn, queue.Config{
+		CopyPolicies:   config.CopyPolicies,
+		NearDuplicates: config.NearDuplicates,
+		ResultCache:    config.ResultCache,
+		Resize:         config.Resize,
+		Quality:        config.Quality,
+		Colors:         config.Colors,
+		Tiles:          config.Tiles,
+		Crops:          config.Crops,
+		Sources:        sources,
+	})
 }
@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/image"
+)
+
+// base64ChunkSize is a multiple of 3, so chunks encode without padding
+const base64ChunkSize = 48 * 1024
+
+// imagePlaceholder stands in for an image's data URL in the payload until
+// the request body is assembled. encoding/json escapes NUL bytes, so prompt
+// text can never marshal to the same sequence.
+func imagePlaceholder(i int) string {
+	return fmt.Sprintf("\x00image-%d\x00", i)
+}
+
+// requestBody splices the images into the marshaled payload in place of
+// their placeholders, base64-encoding them as the body is read rather than
+// building the whole encoded request in memory
+func requestBody(jsonData []byte, images []*image.Encoded) (io.Reader, int64, error) {
+	var readers []io.Reader
+	var size int64
+
+	rest := jsonData
+	for i, img := range images {
+		marker, err := json.Marshal(imagePlaceholder(i))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to marshal image placeholder: %w", err)
+		}
+		marker = marker[1 : len(marker)-1]
+
+		before, after, found := bytes.Cut(rest, marker)
+		if !found {
+			return nil, 0, fmt.Errorf("image %d is missing from the payload", i)
+		}
+
+		prefix := "data:" + img.MIMEType + ";base64,"
+		readers = append(readers,
+			bytes.NewReader(before),
+			strings.NewReader(prefix),
+			&base64Reader{data: img.Data},
+		)
+		size += int64(len(before) + len(prefix) + base64.StdEncoding.EncodedLen(len(img.Data)))
+		rest = after
+	}
+
+	readers = append(readers, bytes.NewReader(rest))
+	size += int64(len(rest))
+
+	return io.MultiReader(readers...), size, nil
+}
+
+// base64Reader base64-encodes data a chunk at a time as it is read
+type base64Reader struct {
+	data []byte
+	buf  []byte
+	out  []byte
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	if len(r.out) == 0 {
+		if len(r.data) == 0 {
+			return 0, io.EOF
+		}
+		n := min(len(r.data), base64ChunkSize)
+		r.buf = base64.StdEncoding.AppendEncode(r.buf[:0], r.data[:n])
+		r.out = r.buf
+		r.data = r.data[n:]
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/image"
+)
+
+func TestBase64Reader(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, size := range []int{0, 1, 2, 3, base64ChunkSize - 1, base64ChunkSize, base64ChunkSize + 1, 3*base64ChunkSize + 2} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(rng.IntN(256))
+		}
+		want := base64.StdEncoding.EncodeToString(data)
+
+		got, err := io.ReadAll(&base64Reader{data: data})
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if string(got) != want {
+			t.Errorf("%d bytes: encoding differs from base64.StdEncoding", size)
+		}
+
+		// One byte at a time, as a slow consumer would read it
+		if err := iotest.TestReader(&base64Reader{data: data}, []byte(want)); err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+		}
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	images := []*image.Encoded{
+		{Data: []byte("first image"), MIMEType: "image/jpeg"},
+		{Data: []byte("second"), MIMEType: "image/png"},
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"content": []string{imagePlaceholder(0), "text", imagePlaceholder(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, size, err := requestBody(payload, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(got)) != size {
+		t.Errorf("size = %d, body is %d bytes", size, len(got))
+	}
+
+	want, err := json.Marshal(map[string]interface{}{
+		"content": []string{
+			"data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(images[0].Data),
+			"text",
+			"data:image/png;base64," + base64.StdEncoding.EncodeToString(images[1].Data),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("body = %s\nwant %s", got, want)
+	}
+}
+
+func TestRequestBodyMissingImage(t *testing.T) {
+	payload := []byte(`{"content":"no images"}`)
+	images := []*image.Encoded{{Data: []byte("x"), MIMEType: "image/jpeg"}}
+	if _, _, err := requestBody(payload, images); err == nil {
+		t.Error("requestBody without the image placeholder succeeded")
+	}
+}
@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Category guidance shared by every analysis mode
+const taxonomyGuidance = `For example, if the main visible product is:
+	- Any clothing on the upper body will be classified under "Clothing > Clothing Tops"
+	- A full suit or tuxedo: use a "Suits > Tuxedos" style path.
+	- A jacket or blazer: use the appropriate "Outerwear" / "Coats & Jackets" path.
+	- DO NOT use Skirt Suits or Pant Suits taxonomy if the product is not a feminine skirt suit or feminine pant suit. If it isn't a tuxedo, use Clothing > Suits instead!
+	- Footwear: only use "Shoes" branches (e.g. Boots, Sneakers) when the product is clearly footwear.
+	- Costumes: only use "Costumes" branches (e.g. Halloween, Mardi Gras, etc.) when the product is clearly a costume.
+	- Any handbag, wallet, backpack, case, etc.: Only use "Handbags, Wallets & Cases" branches when the product is clearly a handbag, wallet, backpack, case, etc.
+	- For hangbags, pay attention to the type of bag, such as crossbody, shoulder, tote, backpack, etc.
+	- Landyards, Keychains, Wallet Chains: Only use "Handbag & Wallet Accessories" branches when the product is clearly a handbag accessory or wallet accessory.
+	- Belts, Hats, Wristbands, etc.: Only use "Clothing Accessories" branches when the product is clearly a belt, hat, wristband, etc.
+	- Any other wearables that don't fit under regular clothing or accessories or bags: Use "Clothing Accessories" branches when the product is clearly a wearable that doesn't fit under regular clothing, accessories, or bags.
+	- All jewelry, such as chains, bracelets, earrings, rings, chain belts, etc.: Only use "Jewelry" branches when the product is clearly a piece of jewelry.
+	- All jewelry on the body, such as chain belts, nose rings, belly rings, toe rings, etc.: Use "Jewelry > Body jewelry" branches when the product is clearly a piece of jewelry on the body.
+	- Watches: Use "Jewelry > Watches" branches when the product is clearly a watch.
+	- Smartwatches: Use "Jewelry > Smartwatches" branches when the product is clearly a smartwatch.
+	- Anything shoe related but aren't shoes, such as shoe covers, grips, gel pads, shoelaces, etc.: Use "Shoe Accessories" branches when the product is clearly a shoe accessory.
+	- Any bras, bodysuits, jockstraps, other lingerie items: Use "Lingerie" branches when the product is clearly a lingerie item.
+
+	The taxonomy string MUST be a valid path starting with "Apparel & Accessories" and using only exact names from the taxonomy.`
+
+const violationGuidance = `Also check the image against the marketplace listing policy and flag:
+	- prohibited_item: drugs, drug paraphernalia, counterfeit goods, animal products from protected species, or other items that may not be sold.
+	- adult_content: nudity, sexually explicit imagery or adult products.
+	- weapons: firearms, ammunition, knives or other weapons (including weapon-shaped accessories that look real).
+	- third_party_logo: clearly visible logos or trademarks of a brand, team or character.
+	- text_watermark: watermarks, stock photo marks, or overlaid text/URLs that are not printed on the product itself.
+	For each flag set "flagged" to true or false. When flagged, "reason" must briefly say what you saw; otherwise leave "reason" empty.`
+
+const attributeGuidance = `The attributes offered depend on the chosen category (e.g. heel height only for shoes). Fill each one with the allowed values that clearly apply; use an empty list when an attribute can't be determined from the image.`
+
+const taxonomyRules = `Rules for TAXONOMY:
+	- Always start with: "Apparel & Accessories > ..."
+	- Only use category names that exist in the taxonomy.
+	- Always go to the most specific leaf possible.
+	- Do NOT output just "Apparel & Accessories".`
+
+func buildPrompts(imageCount int, opts AnalyzeOptions) (string, string) {
+	var systemPrompt, userPrompt string
+
+	if opts.MultiProduct {
+		systemPrompt = `You are a product analysis assistant for fashion images.
+	You must:
+	- Identify EVERY distinct product visible in the image (e.g. jacket, jeans and sneakers in an outfit photo), not just the main one.
+	- Ignore the model wearing them, props and background objects that are not for sale.
+	- Classify each product separately using the provided apparel taxonomy.
+	- Always select the MOST SPECIFIC leaf category that applies (never stop at a broad node like "Apparel & Accessories").
+	
+	` + taxonomyGuidance
+
+		userPrompt = `Analyze every product in this image and provide a JSON response with one entry per product in "items".
+
+	For each item:
+	1. TITLE: A specific, descriptive product name.
+	2. TAXONOMY: The most specific valid category path from the apparel taxonomy.
+	3. ATTRIBUTES: ` + attributeGuidance + `
+	4. BBOX: A rough bounding box [x1, y1, x2, y2] around the product, in relative coordinates from 0 to 1000 where [0, 0] is the top-left corner of the image.
+	
+	` + taxonomyRules + `
+	
+	VIOLATIONS: ` + violationGuidance + `
+	
+	Respond with JSON only (no other text).`
+	} else {
+		systemPrompt = `You are a product analysis assistant for fashion images.
+	You must:
+	- Identify the single main product being sold in the image (the primary focus).
+	- When several images are provided, they all show the SAME product from different angles or in detail. Combine what you see across all of them into one answer.
+	- Classify it using the provided apparel taxonomy.
+	- Always select the MOST SPECIFIC leaf category that applies (never stop at a broad node like "Apparel & Accessories").
+	
+	` + taxonomyGuidance
+
+		userPrompt = `Analyze the product in this image and provide a JSON response.
+
+	1. TITLE: A specific, descriptive product name for the main item being sold.
+	2. DESCRIPTION: Describe the real visual details - colors, materials, design features, branding, style, and what garment or footwear it is.
+	3. TAXONOMY: Map the main product to the most specific valid category path from the apparel taxonomy.
+	4. ATTRIBUTES: ` + attributeGuidance + `
+	5. TAGS: Up to 10 short search tags shoppers would type to find this product (e.g. "leather jacket", "biker").
+	6. ALT_TEXT: A concise accessibility description of the image for screen readers, stating what the product looks like.
+	7. META_DESCRIPTION: A compelling search result snippet of at most 160 characters.
+	8. HANDLE: A short URL slug for the product page using lowercase words separated by hyphens (e.g. "black-leather-biker-jacket").
+	9. VIOLATIONS: ` + violationGuidance + `
+	10. BBOX: A tight bounding box [x1, y1, x2, y2] around the main product in the first image, in relative coordinates from 0 to 1000 where [0, 0] is the top-left corner of the image.
+	
+	` + taxonomyRules + `
+	
+	Respond with JSON only (no other text).`
+	}
+
+	if opts.CopyPolicy != nil {
+		if rules := opts.CopyPolicy.PromptRules(); rules != "" {
+			userPrompt += "\n\n\t" + rules
+		}
+	}
+
+	if !opts.MultiProduct && len(opts.ColorHints) > 0 {
+		colors := make([]string, len(opts.ColorHints))
+		for i, c := range opts.ColorHints {
+			colors[i] = fmt.Sprintf("%s %.0f%%", c.Name, c.Share*100)
+		}
+		userPrompt += "\n\n\tMeasured product colors (share of the product): " + strings.Join(colors, ", ") +
+			". Use these color names in the description and color attribute unless the image clearly shows otherwise."
+	}
+
+	if opts.Feedback != "" {
+		userPrompt += "\n\n\t" + opts.Feedback
+	}
+
+	if imageCount > 1 {
+		userPrompt = fmt.Sprintf("The following %d images show the same product from different angles. ", imageCount) + userPrompt
+	}
+
+	return systemPrompt, userPrompt
+}
+
+// tilePrompt explains the tiles that follow the overview image
+func tilePrompt(grid int) string {
+	return fmt.Sprintf("The first image is the full photo. The %d images after it are zoomed-in, high-resolution tiles of it in a %dx%d grid, in reading order (left to right, top to bottom). Use them to read small details such as care labels, logos, branding and texture. Bounding boxes always refer to the first image.", grid*grid, grid, grid)
+}
+
+var localeNames = map[string]string{
+	"fr":    "French",
+	"de":    "German",
+	"es":    "Spanish",
+	"it":    "Italian",
+	"nl":    "Dutch",
+	"pt-BR": "Brazilian Portuguese",
+	"ja":    "Japanese",
+}
+
+func buildLocalizePrompts(title string, description string, taxonomy string, locale string) (string, string) {
+	language, ok := localeNames[locale]
+	if !ok {
+		language = locale
+	}
+
+	systemPrompt := fmt.Sprintf(`You are a professional e-commerce translator for fashion products.
+	Translate product listings into natural, fluent %s as a native copywriter would write them.
+	Keep brand names, model names and sizes unchanged.`, language)
+
+	userPrompt := fmt.Sprintf(`Translate this product listing into %s and provide a JSON response.
+
+	Title: %s
+	Description: %s
+	Category (English): %s
+
+	1. TITLE: The translated product title.
+	2. DESCRIPTION: The translated description, keeping every visual detail.
+	3. TAXONOMY: The same category path, using the official %s category names. Go to the same depth as the English path.
+	
+	Respond with JSON only (no other text).`, language, title, description, taxonomy, language)
+
+	return systemPrompt, userPrompt
+}
@@ -1,8 +1,8 @@
 package ai
 
 import (
-	"bytes"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,22 +11,91 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/copypolicy"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/image"
 )
 
+// Sampling settings for image analysis, part of a result's provenance
+const (
+	analyzeMaxTokens   = 1536
+	analyzeTemperature = 0.05
+)
+
+// Context budget of the model server
+const (
+	contextSize = 8192
+	// Qwen3-VL merges 16px patches 2x2, so each image token covers 32x32
+	// pixels
+	pixelsPerImageToken = 32 * 32
+	// contextMargin covers the chat template and image delimiter tokens
+	contextMargin = 256
+)
+
 type Engine struct {
-	cmd     *exec.Cmd
-	apiURL  string
-	grammar string
+	cmd            *exec.Cmd
+	apiURL         string
+	model          string
+	grammar        string
+	multiGrammar   string
+	localeGrammars map[string]string
+	locales        []string
+}
+
+// AnalyzeOptions selects how a job's images are analyzed
+type AnalyzeOptions struct {
+	// MultiProduct returns every product in the image with a bounding box
+	// instead of a single main product
+	MultiProduct bool
+	// CopyPolicy adds the merchant's copy rules to the prompt and grammar
+	CopyPolicy *copypolicy.Policy
+	// Feedback explains what was wrong with a previous attempt when
+	// re-generating
+	Feedback string
+	// Resize is the policy the images were decoded with, recorded in the
+	// provenance
+	Resize image.ResizePolicy
+	// Tiles adds high-resolution crops of a single image for fine detail
+	Tiles image.TilePolicy
+	// ColorHints are the product's measured dominant colors, so
+	// descriptions name colors consistently
+	ColorHints []image.DominantColor
 }
 
-func NewEngine(llamaServerPath string, modelPath string, grammarPath string, acceleration string, gpuLayers int) (*Engine, error) {
+func NewEngine(llamaServerPath string, modelPath string, grammarPath string, multiGrammarPath string, localeGrammarPaths map[string]string, locales []string, acceleration string, gpuLayers int) (*Engine, error) {
 	grammarBytes, err := os.ReadFile(grammarPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read grammar: %w", err)
 	}
+	if missing := missingRules(string(grammarBytes), grammarRules...); len(missing) > 0 {
+		fmt.Printf("Warning: grammar %s has no %s rules, regenerate it with cmd/gen-grammar\n", grammarPath, strings.Join(missing, ", "))
+	}
+
+	// The multi-product grammar is optional; without it the mode is unavailable
+	var multiGrammar string
+	if multiGrammarPath != "" {
+		multiGrammarBytes, err := os.ReadFile(multiGrammarPath)
+		if err != nil {
+			fmt.Printf("Warning: failed to read multi-product grammar: %v\n", err)
+		} else {
+			multiGrammar = string(multiGrammarBytes)
+		}
+	}
+
+	// Locale grammars are optional too; locales without one are skipped
+	localeGrammars := make(map[string]string)
+	var availableLocales []string
+	for _, locale := range locales {
+		localeGrammarBytes, err := os.ReadFile(localeGrammarPaths[locale])
+		if err != nil {
+			fmt.Printf("Warning: failed to read %s grammar, locale disabled: %v\n", locale, err)
+			continue
+		}
+		localeGrammars[locale] = string(localeGrammarBytes)
+		availableLocales = append(availableLocales, locale)
+	}
 
 	// Detect mmproj file - it should be in the same directory with "mmproj-" prefix
 	modelDir := filepath.Dir(modelPath)
@@ -37,7 +106,7 @@ func NewEngine(llamaServerPath string, modelPath string, grammarPath string, acc
 	args := []string{
 		"-m", modelPath,
 		"--port", "8080",
-		"-c", "8192", // Increased for high-resolution product images (was 2048)
+		"-c", fmt.Sprintf("%d", contextSize), // Increased for high-resolution product images (was 2048)
 	}
 
 	// Add acceleration-specific flags
@@ -81,7 +150,7 @@ func NewEngine(llamaServerPath string, modelPath string, grammarPath string, acc
 	// macOS uses DYLD_LIBRARY_PATH, Linux uses LD_LIBRARY_PATH
 	llamaBasePath := filepath.Dir(llamaServerPath)
 	if runtime.GOOS == "darwin" {
-	cmd.Env = append(os.Environ(), fmt.Sprintf("DYLD_LIBRARY_PATH=%s", llamaBasePath))
+		cmd.Env = append(os.Environ(), fmt.Sprintf("DYLD_LIBRARY_PATH=%s", llamaBasePath))
 	} else {
 		cmd.Env = append(os.Environ(), fmt.Sprintf("LD_LIBRARY_PATH=%s", llamaBasePath))
 	}
@@ -97,9 +166,13 @@ func NewEngine(llamaServerPath string, modelPath string, grammarPath string, acc
 	}
 
 	return &Engine{
-		cmd:     cmd,
-		apiURL:  "http://localhost:8080",
-		grammar: string(grammarBytes),
+		cmd:            cmd,
+		apiURL:         "http://localhost:8080",
+		model:          modelBaseName,
+		grammar:        string(grammarBytes),
+		multiGrammar:   multiGrammar,
+		localeGrammars: localeGrammars,
+		locales:        availableLocales,
 	}, nil
 }
 
@@ -110,63 +183,61 @@ func (e *Engine) Close() {
 	}
 }
 
-func (e *Engine) AnalyzeImage(imagePath string) (string, error) {
-	// Resize image to 768px on shortest side for optimal LLM processing
-	resizedPath, err := image.ResizeToMinDimension(imagePath, 768)
+// AnalyzeImage sends every photo of the product (front, back, detail shots)
+// in one chat message so the model returns a single consolidated result.
+func (e *Engine) AnalyzeImage(images []*image.Decoded, opts AnalyzeOptions) (string, error) {
+	if len(images) == 0 {
+		return "", fmt.Errorf("no images to analyze")
+	}
+
+	grammar, err := e.grammarFor(opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to resize image: %w", err)
+		return "", err
 	}
 
-	// Clean up temp file if a new one was created
-	if resizedPath != imagePath {
-		defer os.Remove(resizedPath)
+	systemPrompt, userPrompt := buildPrompts(len(images), opts)
+
+	var encodedImages []*image.Encoded
+	if opts.Tiles.Enabled && len(images) == 1 {
+		// Whatever context the prompts and answer leave goes to the tiles
+		textTokens := (len(systemPrompt) + len(userPrompt) + len(tilePrompt(opts.Tiles.Grid))) / 4
+		maxPixels := (contextSize - analyzeMaxTokens - textTokens - contextMargin) * pixelsPerImageToken
+		tiled, err := image.Tile(images[0], opts.Tiles, maxPixels)
+		if err != nil {
+			return "", fmt.Errorf("failed to tile image: %w", err)
+		}
+		encodedImages = append([]*image.Encoded{tiled.Overview}, tiled.Tiles...)
+		if len(tiled.Tiles) > 0 {
+			userPrompt += "\n\n\t" + tilePrompt(tiled.Grid)
+		}
+	} else {
+		for i, img := range images {
+			encoded, err := image.Resize(img)
+			if err != nil {
+				return "", fmt.Errorf("failed to resize image %d: %w", i+1, err)
+			}
+			encodedImages = append(encodedImages, encoded)
+		}
 	}
 
-	base64Image, err := encodeFileToBase64(resizedPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to encode image: %w", err)
-	}
-
-	systemPrompt := `You are a product analysis assistant for fashion images.
-	You must:
-	- Identify the single main product being sold in the image (the primary focus).
-	- Classify it using the provided apparel taxonomy.
-	- Always select the MOST SPECIFIC leaf category that applies (never stop at a broad node like "Apparel & Accessories").
-	
-	For example, if the main visible product is:
-	- Any clothing on the upper body will be classified under "Clothing > Clothing Tops"
-	- A full suit or tuxedo: use a "Suits > Tuxedos" style path.
-	- A jacket or blazer: use the appropriate "Outerwear" / "Coats & Jackets" path.
-	- DO NOT use Skirt Suits or Pant Suits taxonomy if the product is not a feminine skirt suit or feminine pant suit. If it isn't a tuxedo, use Clothing > Suits instead!
-	- Footwear: only use "Shoes" branches (e.g. Boots, Sneakers) when the product is clearly footwear.
-	- Costumes: only use "Costumes" branches (e.g. Halloween, Mardi Gras, etc.) when the product is clearly a costume.
-	- Any handbag, wallet, backpack, case, etc.: Only use "Handbags, Wallets & Cases" branches when the product is clearly a handbag, wallet, backpack, case, etc.
-	- For hangbags, pay attention to the type of bag, such as crossbody, shoulder, tote, backpack, etc.
-	- Landyards, Keychains, Wallet Chains: Only use "Handbag & Wallet Accessories" branches when the product is clearly a handbag accessory or wallet accessory.
-	- Belts, Hats, Wristbands, etc.: Only use "Clothing Accessories" branches when the product is clearly a belt, hat, wristband, etc.
-	- Any other wearables that don't fit under regular clothing or accessories or bags: Use "Clothing Accessories" branches when the product is clearly a wearable that doesn't fit under regular clothing, accessories, or bags.
-	- All jewelry, such as chains, bracelets, earrings, rings, chain belts, etc.: Only use "Jewelry" branches when the product is clearly a piece of jewelry.
-	- All jewelry on the body, such as chain belts, nose rings, belly rings, toe rings, etc.: Use "Jewelry > Body jewelry" branches when the product is clearly a piece of jewelry on the body.
-	- Watches: Use "Jewelry > Watches" branches when the product is clearly a watch.
-	- Smartwatches: Use "Jewelry > Smartwatches" branches when the product is clearly a smartwatch.
-	- Anything shoe related but aren't shoes, such as shoe covers, grips, gel pads, shoelaces, etc.: Use "Shoe Accessories" branches when the product is clearly a shoe accessory.
-	- Any bras, bodysuits, jockstraps, other lingerie items: Use "Lingerie" branches when the product is clearly a lingerie item.
-
-	The taxonomy string MUST be a valid path starting with "Apparel & Accessories" and using only exact names from the taxonomy.`
-
-	userPrompt := `Analyze the product in this image and provide a JSON response.
-
-	1. TITLE: A specific, descriptive product name for the main item being sold.
-	2. DESCRIPTION: Describe the real visual details - colors, materials, design features, branding, style, and what garment or footwear it is.
-	3. TAXONOMY: Map the main product to the most specific valid category path from the apparel taxonomy.
-	
-	Rules for TAXONOMY:
-	- Always start with: "Apparel & Accessories > ..."
-	- Only use category names that exist in the taxonomy.
-	- Always go to the most specific leaf possible.
-	- Do NOT output just "Apparel & Accessories".
-	
-	Respond with JSON only (no other text).`
+	// The data URLs are streamed into the request body by chat
+	imageParts := make([]map[string]interface{}, 0, len(encodedImages))
+	for i := range encodedImages {
+		imageParts = append(imageParts, map[string]interface{}{
+			"type": "image_url",
+			"image_url": map[string]string{
+				"url": imagePlaceholder(i),
+			},
+		})
+	}
+
+	userContent := []map[string]interface{}{
+		{
+			"type": "text",
+			"text": userPrompt,
+		},
+	}
+	userContent = append(userContent, imageParts...)
 
 	payload := map[string]interface{}{
 		"model": "qwen3vl",
@@ -176,37 +247,78 @@ func (e *Engine) AnalyzeImage(imagePath string) (string, error) {
 				"content": systemPrompt,
 			},
 			{
-				"role": "user",
-				"content": []map[string]interface{}{
-					{
-						"type": "text",
-						"text": userPrompt,
-					},
-					{
-						"type": "image_url",
-						"image_url": map[string]string{
-							"url": fmt.Sprintf("data:image/jpeg;base64,%s", base64Image),
-						},
-					},
-				},
+				"role":    "user",
+				"content": userContent,
 			},
 		},
-		"max_tokens":  768,
-		"temperature": 0.05,
-		"grammar":     e.grammar,
+		"max_tokens":  analyzeMaxTokens,
+		"temperature": analyzeTemperature,
+		"grammar":     grammar,
+	}
+
+	return e.chat(payload, encodedImages...)
+}
+
+func (e *Engine) grammarFor(opts AnalyzeOptions) (string, error) {
+	grammar := e.grammar
+	if opts.MultiProduct {
+		if e.multiGrammar == "" {
+			return "", fmt.Errorf("multi-product mode is not available: no multi-product grammar loaded")
+		}
+		grammar = e.multiGrammar
+	}
+	if opts.CopyPolicy != nil {
+		grammar = opts.CopyPolicy.ConstrainGrammar(grammar)
+	}
+	return grammar, nil
+}
+
+// Provenance hashes everything besides the images that determines an
+// analysis result: model, grammar, prompts, resizing and sampling settings.
+// A cached result is only valid for the same provenance.
+func (e *Engine) Provenance(imageCount int, opts AnalyzeOptions) (string, error) {
+	grammar, err := e.grammarFor(opts)
+	if err != nil {
+		return "", err
 	}
+	systemPrompt, userPrompt := buildPrompts(imageCount, opts)
+
+	h := sha256.New()
+	for _, part := range []string{
+		e.model,
+		grammar,
+		systemPrompt,
+		userPrompt,
+		opts.Resize.String(),
+		opts.Tiles.String(),
+		fmt.Sprintf("%d/%g", analyzeMaxTokens, analyzeTemperature),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
+// chat sends a request to the OpenAI-compatible chat completions endpoint
+// and returns the generated message content. images replace the payload's
+// image placeholders, in order.
+func (e *Engine) chat(payload map[string]interface{}, images ...*image.Encoded) (string, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	fmt.Printf("Sending chat request (payload size: %d bytes)\n", len(jsonData))
+	body, size, err := requestBody(jsonData, images)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request body: %w", err)
+	}
+
+	fmt.Printf("Sending chat request (payload size: %d bytes)\n", size)
 
-	req, err := http.NewRequest("POST", e.apiURL+"/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", e.apiURL+"/v1/chat/completions", body)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
+	req.ContentLength = size
 	req.Header.Set("Content-Type", "application/json")
 
 	fmt.Printf("Making request to: %s\n", e.apiURL+"/v1/chat/completions")
@@ -256,6 +368,58 @@ func (e *Engine) AnalyzeImage(imagePath string) (string, error) {
 	return content, nil
 }
 
+// grammarRules are the rules a grammar written by the current
+// cmd/gen-grammar defines; grammars from older versions lack some of the
+// result fields
+var grammarRules = []string{"taxonomy-end-0", "seo", "bbox"}
+
+// missingRules returns the rules the grammar doesn't define
+func missingRules(grammar string, rules ...string) []string {
+	var missing []string
+	for _, rule := range rules {
+		if !strings.HasPrefix(grammar, rule+" ::=") && !strings.Contains(grammar, "\n"+rule+" ::=") {
+			missing = append(missing, rule)
+		}
+	}
+	return missing
+}
+
+// Locales returns the configured output locales that have a grammar loaded
+func (e *Engine) Locales() []string {
+	return e.locales
+}
+
+// Localize translates an analysis result into the locale. It is a
+// text-only request, so the images aren't re-sent; the locale grammar
+// constrains the taxonomy to the localized category names.
+func (e *Engine) Localize(title string, description string, taxonomy string, locale string) (string, error) {
+	grammar, ok := e.localeGrammars[locale]
+	if !ok {
+		return "", fmt.Errorf("locale %q is not available", locale)
+	}
+
+	systemPrompt, userPrompt := buildLocalizePrompts(title, description, taxonomy, locale)
+
+	payload := map[string]interface{}{
+		"model": "qwen3vl",
+		"messages": []map[string]interface{}{
+			{
+				"role":    "system",
+				"content": systemPrompt,
+			},
+			{
+				"role":    "user",
+				"content": userPrompt,
+			},
+		},
+		"max_tokens":  1024,
+		"temperature": 0.05,
+		"grammar":     grammar,
+	}
+
+	return e.chat(payload)
+}
+
 func waitForServer(url string, timeout time.Duration) bool {
 	deadline := time.Now().Add(timeout)
 	tick := time.NewTicker(1 * time.Second)
@@ -285,11 +449,3 @@ func waitForServer(url string, timeout time.Duration) bool {
 		}
 	}
 }
-
-func encodeFileToBase64(path string) (string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	return base64.StdEncoding.EncodeToString(data), nil
-}
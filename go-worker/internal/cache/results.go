@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "image2taxonomy:result:"
+	indexKey  = "image2taxonomy:results"
+)
+
+type Config struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	// MaxEntries caps the number of cached results; the oldest are evicted
+	MaxEntries int64 `yaml:"max_entries"`
+	// MaxEntryBytes skips caching unusually large results
+	MaxEntryBytes int `yaml:"max_entry_bytes"`
+}
+
+// Results caches raw model output in Redis, keyed by the SHA-256 of the
+// input images and the provenance of everything else that shaped the
+// result, so identical requests are never re-inferred
+type Results struct {
+	rdb       *redis.Client
+	cfg       Config
+	writeOnly bool
+}
+
+// NewResults returns nil when caching is disabled; a nil *Results always
+// misses and never stores
+func NewResults(rdb *redis.Client, cfg Config) *Results {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Results{rdb: rdb, cfg: cfg}
+}
+
+// Key builds the cache key for images and a provenance hash
+func Key(images [][]byte, provenance string) string {
+	h := sha256.New()
+	for _, data := range images {
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "image:%s\n", hex.EncodeToString(sum[:]))
+	}
+	fmt.Fprintf(h, "provenance:%s\n", provenance)
+	return keyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteOnly returns a copy that never reads, for forced re-analysis that
+// should still refresh the cache
+func (r *Results) WriteOnly() *Results {
+	if r == nil {
+		return nil
+	}
+	return &Results{rdb: r.rdb, cfg: r.cfg, writeOnly: true}
+}
+
+func (r *Results) Get(key string) (string, bool) {
+	if r == nil || r.writeOnly {
+		return "", false
+	}
+
+	value, err := r.rdb.Get(context.Background(), key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Result cache read failed: %v\n", err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (r *Results) Set(key string, value string) {
+	if r == nil {
+		return
+	}
+	if r.cfg.MaxEntryBytes > 0 && len(value) > r.cfg.MaxEntryBytes {
+		fmt.Printf("Result too large to cache (%d bytes)\n", len(value))
+		return
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+
+	pipe := r.rdb.TxPipeline()
+	pipe.Set(ctx, key, value, r.cfg.TTL)
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(now.Unix()), Member: key})
+	if r.cfg.TTL > 0 {
+		// Expired results drop out of Redis by themselves; forget them in the index too
+		pipe.ZRemRangeByScore(ctx, indexKey, "-inf", fmt.Sprintf("(%d", now.Add(-r.cfg.TTL).Unix()))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Result cache write failed: %v\n", err)
+		return
+	}
+
+	r.evict(ctx)
+}
+
+// evict removes the oldest results beyond MaxEntries
+func (r *Results) evict(ctx context.Context) {
+	if r.cfg.MaxEntries <= 0 {
+		return
+	}
+
+	count, err := r.rdb.ZCard(ctx, indexKey).Result()
+	if err != nil || count <= r.cfg.MaxEntries {
+		return
+	}
+
+	oldest, err := r.rdb.ZPopMin(ctx, indexKey, count-r.cfg.MaxEntries).Result()
+	if err != nil {
+		log.Printf("Result cache eviction failed: %v\n", err)
+		return
+	}
+
+	keys := make([]string, 0, len(oldest))
+	for _, entry := range oldest {
+		keys = append(keys, entry.Member.(string))
+	}
+	if err := r.rdb.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("Result cache eviction failed: %v\n", err)
+	}
+}
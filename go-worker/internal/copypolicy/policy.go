@@ -0,0 +1,269 @@
+package copypolicy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Policy holds a merchant's rules for generated copy
+type Policy struct {
+	MaxTitleLength int      `yaml:"max_title_length"`
+	BannedWords    []string `yaml:"banned_words"`
+	TitleCase      string   `yaml:"title_case"`  // title, sentence, upper or empty to leave as-is
+	TitleOrder     []string `yaml:"title_order"` // e.g. brand, color, item
+	MaxRetries     int      `yaml:"max_retries"` // re-generations on violation
+}
+
+// Title order components that can be checked against the result's attributes
+var orderAttributes = map[string]string{
+	"color":    "color",
+	"material": "fabric",
+	"fabric":   "fabric",
+	"pattern":  "pattern",
+}
+
+// Words kept lowercase in title case unless they start the title
+var minorWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "by": true, "for": true,
+	"in": true, "of": true, "on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// PromptRules describes the policy to the model
+func (p *Policy) PromptRules() string {
+	var rules []string
+	if p.MaxTitleLength > 0 {
+		rules = append(rules, fmt.Sprintf("- The title must be at most %d characters.", p.MaxTitleLength))
+	}
+	if len(p.BannedWords) > 0 {
+		rules = append(rules, fmt.Sprintf("- Never use these words anywhere: %s.", strings.Join(p.BannedWords, ", ")))
+	}
+	switch p.TitleCase {
+	case "title":
+		rules = append(rules, "- Write the title in Title Case.")
+	case "sentence":
+		rules = append(rules, "- Write the title in sentence case (only the first word and proper names capitalized).")
+	case "upper":
+		rules = append(rules, "- Write the title in UPPERCASE.")
+	}
+	if len(p.TitleOrder) > 0 {
+		parts := make([]string, len(p.TitleOrder))
+		for i, part := range p.TitleOrder {
+			parts[i] = capitalize(part)
+		}
+		rules = append(rules, fmt.Sprintf("- Build the title in this order: %s (skip parts that aren't visible).", strings.Join(parts, " + ")))
+	}
+
+	if len(rules) == 0 {
+		return ""
+	}
+	return "COPY RULES:\n\t" + strings.Join(rules, "\n\t")
+}
+
+// ConstrainGrammar enforces the title rules that GBNF can express (length
+// and uppercase) by swapping the title's string rule in the root
+func (p *Policy) ConstrainGrammar(grammar string) string {
+	if p.MaxTitleLength <= 0 && p.TitleCase != "upper" {
+		return grammar
+	}
+
+	titleRule := `"\"title\":" ws string`
+	if !strings.Contains(grammar, titleRule) {
+		return grammar
+	}
+
+	repeat := "*"
+	if p.MaxTitleLength > 0 {
+		repeat = fmt.Sprintf("{1,%d}", p.MaxTitleLength)
+	}
+	titleChar := "char"
+	if p.TitleCase == "upper" {
+		titleChar = `([^"\\a-z] | "\\" ["\\/bfnrt])`
+	}
+
+	grammar = strings.Replace(grammar, titleRule, `"\"title\":" ws policy-title`, 1)
+	return grammar + "\npolicy-title ::= \"\\\"\" " + titleChar + repeat + " \"\\\"\"\n"
+}
+
+// Apply makes the deterministic fixes (casing and whitespace) to every
+// title in the result
+func (p *Policy) Apply(result map[string]interface{}) {
+	for _, product := range products(result) {
+		title, ok := product["title"].(string)
+		if !ok {
+			continue
+		}
+		product["title"] = p.applyCase(strings.Join(strings.Fields(title), " "))
+	}
+}
+
+// Truncate shortens titles over the length limit at a word boundary, as a
+// last resort once re-generation is exhausted
+func (p *Policy) Truncate(result map[string]interface{}) {
+	if p.MaxTitleLength <= 0 {
+		return
+	}
+	for _, product := range products(result) {
+		title, ok := product["title"].(string)
+		if !ok || len([]rune(title)) <= p.MaxTitleLength {
+			continue
+		}
+		cut := string([]rune(title)[:p.MaxTitleLength])
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+		product["title"] = strings.TrimRight(cut, " ,;:-")
+	}
+}
+
+// Validate returns a human-readable description of every rule the result
+// breaks, or nil if it complies
+func (p *Policy) Validate(result map[string]interface{}) []string {
+	var problems []string
+
+	for _, product := range products(result) {
+		title, _ := product["title"].(string)
+
+		if p.MaxTitleLength > 0 && len([]rune(title)) > p.MaxTitleLength {
+			problems = append(problems, fmt.Sprintf("title %q is %d characters, the limit is %d", title, len([]rune(title)), p.MaxTitleLength))
+		}
+
+		problems = append(problems, p.checkOrder(title, product)...)
+	}
+
+	for _, text := range copyText(result) {
+		for _, word := range p.BannedWords {
+			if containsWord(text, word) {
+				problems = append(problems, fmt.Sprintf("uses banned word %q", word))
+			}
+		}
+	}
+
+	return dedupe(problems)
+}
+
+// checkOrder verifies that the title mentions the attribute values named in
+// TitleOrder, in that order. Components without an attribute (brand, item)
+// can't be checked and are skipped.
+func (p *Policy) checkOrder(title string, product map[string]interface{}) []string {
+	attributes, _ := product["attributes"].(map[string]interface{})
+	if len(p.TitleOrder) == 0 || attributes == nil {
+		return nil
+	}
+
+	var problems []string
+	lowerTitle := strings.ToLower(title)
+	lastIndex, lastPart := -1, ""
+	for _, part := range p.TitleOrder {
+		handle, ok := orderAttributes[strings.ToLower(part)]
+		if !ok {
+			continue
+		}
+		values, _ := attributes[handle].([]interface{})
+		if len(values) == 0 {
+			continue
+		}
+		value, _ := values[0].(string)
+		if value == "" {
+			continue
+		}
+
+		index := strings.Index(lowerTitle, strings.ToLower(value))
+		if index < 0 {
+			problems = append(problems, fmt.Sprintf("title %q should include the %s (%s)", title, part, value))
+			continue
+		}
+		if index < lastIndex {
+			problems = append(problems, fmt.Sprintf("title %q should put the %s before the %s", title, lastPart, part))
+		}
+		lastIndex, lastPart = index, part
+	}
+	return problems
+}
+
+func (p *Policy) applyCase(title string) string {
+	switch p.TitleCase {
+	case "upper":
+		return strings.ToUpper(title)
+	case "sentence":
+		return capitalize(title)
+	case "title":
+		words := strings.Fields(title)
+		for i, word := range words {
+			if i > 0 && minorWords[strings.ToLower(word)] {
+				words[i] = strings.ToLower(word)
+				continue
+			}
+			words[i] = capitalize(word)
+		}
+		return strings.Join(words, " ")
+	}
+	return title
+}
+
+func capitalize(s string) string {
+	runes := []rune(s)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+// products returns the result itself in single-product mode, or each item
+// in multi-product mode
+func products(result map[string]interface{}) []map[string]interface{} {
+	items, ok := result["items"].([]interface{})
+	if !ok {
+		return []map[string]interface{}{result}
+	}
+
+	var products []map[string]interface{}
+	for _, item := range items {
+		if product, ok := item.(map[string]interface{}); ok {
+			products = append(products, product)
+		}
+	}
+	return products
+}
+
+// copyText returns every customer-facing text field of the result
+func copyText(result map[string]interface{}) []string {
+	var texts []string
+	for _, product := range products(result) {
+		for _, key := range []string{"title", "description", "alt_text", "meta_description"} {
+			if text, ok := product[key].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+		if tags, ok := product["tags"].([]interface{}); ok {
+			for _, tag := range tags {
+				if text, ok := tag.(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+	}
+	return texts
+}
+
+func containsWord(text string, word string) bool {
+	word = strings.TrimSpace(word)
+	if word == "" {
+		return false
+	}
+	pattern := regexp.MustCompile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(word) + `($|[^\pL\pN])`)
+	return pattern.MatchString(text)
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config sizes the connection pool and bounds retries of failed statements
+type Config struct {
+	MaxConns        int32         `yaml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time"`
+	// HealthCheckPeriod is how often idle connections are checked and
+	// broken ones replaced
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+	// MaxRetries is how many times a statement failing with a retryable
+	// error is retried
+	MaxRetries int `yaml:"max_retries"`
+	// RetryBackoff is the wait before the first retry, doubled each time
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+}
+
+// DefaultConfig keeps 4 connections (at least 1 open), recycled hourly or
+// after 30 minutes idle and checked every 30 seconds, and retries 5 times
+// from 500ms, riding out about 15 seconds of a Postgres restart.
+// MaxRetries: 0 turns retries off.
+func DefaultConfig() Config {
+	return Config{
+		MaxConns:          4,
+		MinConns:          1,
+		MaxConnLifetime:   time.Hour,
+		MaxConnIdleTime:   30 * time.Minute,
+		HealthCheckPeriod: 30 * time.Second,
+		MaxRetries:        5,
+		RetryBackoff:      500 * time.Millisecond,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.MaxConns < 1 {
+		return fmt.Errorf("database_pool max_conns must be positive, got %d", c.MaxConns)
+	}
+	if c.MinConns < 0 || c.MinConns > c.MaxConns {
+		return fmt.Errorf("database_pool min_conns must be between 0 and max_conns, got %d", c.MinConns)
+	}
+	if c.MaxConnLifetime <= 0 || c.MaxConnIdleTime <= 0 || c.HealthCheckPeriod <= 0 {
+		return fmt.Errorf("database_pool lifetimes and health check period must be positive")
+	}
+	if c.MaxRetries < 0 || c.RetryBackoff < 0 {
+		return fmt.Errorf("database_pool max_retries and retry_backoff must not be negative")
+	}
+	return nil
+}
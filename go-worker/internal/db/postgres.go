@@ -2,20 +2,74 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Postgres struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+	cfg  Config
 }
 
-func NewConnection(url string) (*Postgres, error) {
-	conn, err := pgx.Connect(context.Background(), url)
+// NewConnection opens a connection pool and waits for the database to
+// accept connections, retrying while it starts up
+func NewConnection(url string, cfg Config) (*Postgres, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	poolConfig.MaxConns = cfg.MaxConns
+	poolConfig.MinConns = cfg.MinConns
+	poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+
+	p := &Postgres{pool: pool, cfg: cfg}
+	if err := p.retry(p.pool.Ping); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return p, nil
+}
+
+func (p *Postgres) Close() {
+	p.pool.Close()
+}
+
+// exec runs a statement, retrying retryable errors. Every statement the
+// worker runs is idempotent, so a retry after a lost connection is safe
+// even if the first attempt was applied.
+func (p *Postgres) exec(query string, args ...any) error {
+	return p.retry(func(ctx context.Context) error {
+		_, err := p.pool.Exec(ctx, query, args...)
+		return err
+	})
+}
+
+// retry runs op until it succeeds, fails with an error that isn't
+// retryable or runs out of retries, doubling the backoff each time
+func (p *Postgres) retry(op func(ctx context.Context) error) error {
+	backoff := p.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op(context.Background())
+		if err == nil || attempt >= p.cfg.MaxRetries || !Retryable(err) {
+			return err
+		}
+		log.Printf("Database error (attempt %d of %d), retrying in %s: %v\n", attempt+1, p.cfg.MaxRetries+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
-	return &Postgres{conn: conn}, nil
 }
 
 func (p *Postgres) UpdateStatus(id int, status string, metadataJSON string) error {
@@ -24,13 +78,213 @@ func (p *Postgres) UpdateStatus(id int, status string, metadataJSON string) erro
 		SET processing_status = $1, 
             title = CASE WHEN $2::json->>'title' IS NOT NULL THEN $2::json->>'title' ELSE title END,
             description = CASE WHEN $2::json->>'description' IS NOT NULL THEN $2::json->>'description' ELSE description END,
+            tags = CASE WHEN $2::json->>'tags' IS NOT NULL THEN ($2::json->'tags')::jsonb ELSE tags END,
+            alt_text = CASE WHEN $2::json->>'alt_text' IS NOT NULL THEN $2::json->>'alt_text' ELSE alt_text END,
+            meta_description = CASE WHEN $2::json->>'meta_description' IS NOT NULL THEN $2::json->>'meta_description' ELSE meta_description END,
+            handle = CASE WHEN $2::json->>'handle' IS NOT NULL THEN $2::json->>'handle' ELSE handle END,
             taxonomy = CASE WHEN $2::json->>'taxonomy' IS NOT NULL THEN $2::json->>'taxonomy' ELSE taxonomy END,
             violations = CASE WHEN $2::json->>'violations' IS NOT NULL THEN ($2::json->'violations')::jsonb ELSE violations END,
+            product_attributes = CASE WHEN $2::json->>'attributes' IS NOT NULL THEN ($2::json->'attributes')::jsonb ELSE product_attributes END,
+            translations = CASE WHEN $2::json->>'translations' IS NOT NULL THEN ($2::json->'translations')::jsonb ELSE translations END,
+            items = CASE WHEN $2::json->>'items' IS NOT NULL THEN ($2::json->'items')::jsonb ELSE items END,
             error_message = CASE WHEN $2::json->>'error_message' IS NOT NULL THEN $2::json->>'error_message' ELSE error_message END,
+            bbox = CASE WHEN $2::json->>'bbox' IS NOT NULL THEN ($2::json->'bbox')::jsonb ELSE bbox END,
+            security_flagged = CASE WHEN $2::json->>'security_flagged' IS NOT NULL THEN ($2::json->>'security_flagged')::boolean ELSE security_flagged END,
 			updated_at = NOW()
 		WHERE id = $3
 	`
 
-	_, err := p.conn.Exec(context.Background(), query, status, metadataJSON, id)
-	return err
+	return p.exec(query, status, metadataJSON, id)
+}
+
+// UpdateImageHashes stores the perceptual hashes of a product's image.
+// The unsigned hashes are stored bit-for-bit in signed bigint columns.
+func (p *Postgres) UpdateImageHashes(id int, dHash uint64, pHash uint64) error {
+	query := `
+		UPDATE products
+		SET image_dhash = $1, image_phash = $2, updated_at = NOW()
+		WHERE id = $3
+	`
+
+	return p.exec(query, int64(dHash), int64(pHash), id)
+}
+
+// UpdateImageQuality stores the quality scores of a product's images
+func (p *Postgres) UpdateImageQuality(id int, qualityJSON string) error {
+	query := `
+		UPDATE products
+		SET image_quality = $1::jsonb, updated_at = NOW()
+		WHERE id = $2
+	`
+
+	return p.exec(query, qualityJSON, id)
+}
+
+// UpdateDominantColors stores the measured dominant colors of a product
+func (p *Postgres) UpdateDominantColors(id int, colorsJSON string) error {
+	query := `
+		UPDATE products
+		SET dominant_colors = $1::jsonb, updated_at = NOW()
+		WHERE id = $2
+	`
+
+	return p.exec(query, colorsJSON, id)
+}
+
+// UpdateCrop stores the crop of the product cut from the model's bounding
+// box, replacing any earlier one
+func (p *Postgres) UpdateCrop(id int, data []byte, contentType string) error {
+	query := `
+		INSERT INTO product_crops (product_id, data, content_type, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (product_id) DO UPDATE
+		SET data = EXCLUDED.data, content_type = EXCLUDED.content_type, updated_at = NOW()
+	`
+
+	return p.exec(query, id, data, contentType)
+}
+
+// ClearCrop removes the product's bounding box and crop
+func (p *Postgres) ClearCrop(id int) error {
+	query := `
+		WITH cleared AS (
+			UPDATE products SET bbox = NULL, updated_at = NOW() WHERE id = $1
+		)
+		DELETE FROM product_crops WHERE product_id = $1
+	`
+
+	return p.exec(query, id)
+}
+
+// FindNearDuplicate returns the most recently analyzed single-product
+// result whose image hashes are both within maxDistance bits, or 0 if none
+func (p *Postgres) FindNearDuplicate(id int, dHash uint64, pHash uint64, maxDistance int) (int, error) {
+	query := `
+		SELECT id FROM products
+		WHERE id <> $1
+			AND processing_status = 'complete'
+			AND image_dhash IS NOT NULL AND image_phash IS NOT NULL
+			AND (items IS NULL OR items = '[]'::jsonb)
+			AND bit_count((image_dhash # $2)::bit(64)) <= $4
+			AND bit_count((image_phash # $3)::bit(64)) <= $4
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`
+
+	var sourceID int
+	err := p.retry(func(ctx context.Context) error {
+		return p.pool.QueryRow(ctx, query, id, int64(dHash), int64(pHash), maxDistance).Scan(&sourceID)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return sourceID, err
+}
+
+// Analysis reads the stored analysis of a product in the shape of an
+// analysis result
+func (p *Postgres) Analysis(id int) (map[string]interface{}, error) {
+	query := `
+		SELECT json_build_object(
+			'title', title,
+			'description', description,
+			'taxonomy', taxonomy,
+			'attributes', product_attributes,
+			'tags', tags,
+			'alt_text', alt_text,
+			'meta_description', meta_description,
+			'handle', handle,
+			'translations', translations
+		)
+		FROM products
+		WHERE id = $1
+	`
+
+	var data []byte
+	err := p.retry(func(ctx context.Context) error {
+		return p.pool.QueryRow(ctx, query, id).Scan(&data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse analysis: %w", err)
+	}
+	return result, nil
+}
+
+// ReuseAnalysis copies the analysis of sourceID, including its bounding
+// box and crop, onto the product and flags it as reused
+func (p *Postgres) ReuseAnalysis(id int, sourceID int) error {
+	query := `
+		WITH reused AS (
+			UPDATE products AS p
+			SET processing_status = 'complete',
+				title = src.title,
+				description = src.description,
+				taxonomy = src.taxonomy,
+				product_attributes = src.product_attributes,
+				tags = src.tags,
+				alt_text = src.alt_text,
+				meta_description = src.meta_description,
+				handle = src.handle,
+				violations = src.violations,
+				translations = src.translations,
+				bbox = src.bbox,
+				error_message = NULL,
+				analysis_reused = TRUE,
+				reused_from_id = src.id,
+				updated_at = NOW()
+			FROM products AS src
+			WHERE p.id = $1 AND src.id = $2
+			RETURNING p.id
+		),
+		dropped AS (
+			DELETE FROM product_crops
+			WHERE product_id = $1
+				AND NOT EXISTS (SELECT 1 FROM product_crops WHERE product_id = $2)
+		)
+		INSERT INTO product_crops (product_id, data, content_type, created_at, updated_at)
+		SELECT reused.id, crop.data, crop.content_type, NOW(), NOW()
+		FROM reused JOIN product_crops AS crop ON crop.product_id = $2
+		ON CONFLICT (product_id) DO UPDATE
+		SET data = EXCLUDED.data, content_type = EXCLUDED.content_type, updated_at = NOW()
+	`
+
+	return p.exec(query, id, sourceID)
+}
+
+// Blob is an ActiveStorage blob row
+type Blob struct {
+	Key         string
+	ContentType string
+	// Checksum is the base64 digest ActiveStorage computed on upload
+	Checksum    string
+	ByteSize    int64
+	ServiceName string
+}
+
+// FindBlob reads the ActiveStorage blob with the key, or returns nil if
+// there is none
+func (p *Postgres) FindBlob(key string) (*Blob, error) {
+	query := `
+		SELECT key, COALESCE(content_type, ''), COALESCE(checksum, ''), byte_size, service_name
+		FROM active_storage_blobs
+		WHERE key = $1
+	`
+
+	var blob Blob
+	err := p.retry(func(ctx context.Context) error {
+		return p.pool.QueryRow(ctx, query, key).Scan(
+			&blob.Key, &blob.ContentType, &blob.Checksum, &blob.ByteSize, &blob.ServiceName)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &blob, nil
 }
@@ -0,0 +1,289 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// colorSampleSide is the longest side images are scaled to before
+	// clustering; color proportions survive heavy downscaling
+	colorSampleSide = 96
+	// backgroundDistance is the Lab distance within which a pixel counts as
+	// background and is left out of the clusters
+	backgroundDistance = 12
+	kMeansIterations   = 20
+)
+
+// ColorPolicy controls dominant color extraction
+type ColorPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// Clusters is the k of k-means
+	Clusters int `yaml:"clusters"`
+	// MinShare drops colors covering less of the product than this fraction
+	MinShare float64 `yaml:"min_share"`
+}
+
+// DefaultColorPolicy finds 5 clusters and keeps colors of at least 5%
+func DefaultColorPolicy() ColorPolicy {
+	return ColorPolicy{Clusters: 5, MinShare: 0.05}
+}
+
+func (p ColorPolicy) Validate() error {
+	if p.Clusters < 1 {
+		return fmt.Errorf("colors clusters must be positive, got %d", p.Clusters)
+	}
+	return nil
+}
+
+// DominantColor is one cluster of product pixels
+type DominantColor struct {
+	Hex string `json:"hex"`
+	// Name is the nearest Shopify color attribute value
+	Name string `json:"name"`
+	// Share is the fraction of product (non-background) pixels
+	Share float64 `json:"share"`
+}
+
+// namedColors are reference colors for the Shopify color attribute
+// values. Metallics, clear and multicolor depend on material rather than
+// hue, so they are left to the model.
+var namedColors = []struct {
+	name string
+	rgb  color.RGBA
+}{
+	{"Black", color.RGBA{20, 20, 20, 255}},
+	{"White", color.RGBA{245, 245, 245, 255}},
+	{"Gray", color.RGBA{128, 128, 128, 255}},
+	{"Gray", color.RGBA{190, 190, 190, 255}},
+	{"Gray", color.RGBA{70, 70, 70, 255}},
+	{"Beige", color.RGBA{225, 205, 170, 255}},
+	{"Brown", color.RGBA{110, 70, 40, 255}},
+	{"Brown", color.RGBA{165, 120, 80, 255}},
+	{"Red", color.RGBA{200, 30, 40, 255}},
+	{"Red", color.RGBA{130, 20, 30, 255}},
+	{"Orange", color.RGBA{240, 130, 30, 255}},
+	{"Yellow", color.RGBA{245, 215, 50, 255}},
+	{"Green", color.RGBA{40, 150, 60, 255}},
+	{"Green", color.RGBA{30, 80, 40, 255}},
+	{"Green", color.RGBA{120, 130, 70, 255}},
+	{"Blue", color.RGBA{40, 100, 200, 255}},
+	{"Blue", color.RGBA{120, 170, 220, 255}},
+	{"Navy", color.RGBA{25, 35, 80, 255}},
+	{"Purple", color.RGBA{110, 50, 150, 255}},
+	{"Pink", color.RGBA{240, 150, 180, 255}},
+	{"Pink", color.RGBA{220, 60, 130, 255}},
+}
+
+type lab struct {
+	l, a, b float64
+}
+
+// DominantColors clusters the product's pixels in Lab space, leaving out
+// the background (the color shared by the corners), and returns the
+// clusters covering at least MinShare, largest first
+func DominantColors(d *Decoded, policy ColorPolicy) []DominantColor {
+	var backgroundLab *lab
+	if d.hasBorder {
+		l := toLab(d.border)
+		backgroundLab = &l
+	}
+
+	img := d.Image
+	bounds := img.Bounds()
+	scale := min(1, float64(colorSampleSide)/float64(max(bounds.Dx(), bounds.Dy())))
+	w := max(1, int(float64(bounds.Dx())*scale))
+	h := max(1, int(float64(bounds.Dy())*scale))
+	sample := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Src, nil)
+
+	var pixels []lab
+	var colors []color.RGBA
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := sample.RGBAAt(x, y)
+			l := toLab(c)
+			if backgroundLab != nil && labDistance(l, *backgroundLab) < backgroundDistance {
+				continue
+			}
+			pixels = append(pixels, l)
+			colors = append(colors, c)
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	k := min(policy.Clusters, len(pixels))
+	assignments := kMeans(pixels, k)
+
+	// Average in sRGB for the reported color, so it matches the photo, and
+	// merge clusters that map to the same name
+	type cluster struct {
+		r, g, b, n float64
+	}
+	clusters := make([]cluster, k)
+	for i, c := range colors {
+		cl := &clusters[assignments[i]]
+		cl.r += float64(c.R)
+		cl.g += float64(c.G)
+		cl.b += float64(c.B)
+		cl.n++
+	}
+	var names []string
+	named := map[string]*cluster{}
+	for _, cl := range clusters {
+		if cl.n == 0 {
+			continue
+		}
+		name := nearestColorName(color.RGBA{R: uint8(cl.r / cl.n), G: uint8(cl.g / cl.n), B: uint8(cl.b / cl.n), A: 255})
+		if merged, ok := named[name]; ok {
+			merged.r += cl.r
+			merged.g += cl.g
+			merged.b += cl.b
+			merged.n += cl.n
+			continue
+		}
+		names = append(names, name)
+		named[name] = &cluster{cl.r, cl.g, cl.b, cl.n}
+	}
+
+	var result []DominantColor
+	for _, name := range names {
+		cl := named[name]
+		share := cl.n / float64(len(pixels))
+		if share < policy.MinShare {
+			continue
+		}
+		result = append(result, DominantColor{
+			Hex:   fmt.Sprintf("#%02x%02x%02x", uint8(cl.r/cl.n), uint8(cl.g/cl.n), uint8(cl.b/cl.n)),
+			Name:  name,
+			Share: math.Round(share*100) / 100,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Share > result[j].Share
+	})
+	return result
+}
+
+// kMeans clusters pixels with k-means++ seeding from a fixed seed, so the
+// same image always gives the same colors, and returns each pixel's cluster
+func kMeans(pixels []lab, k int) []int {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	centers := []lab{pixels[rng.IntN(len(pixels))]}
+	distances := make([]float64, len(pixels))
+	for len(centers) < k {
+		var total float64
+		for i, p := range pixels {
+			d := math.MaxFloat64
+			for _, c := range centers {
+				d = min(d, labDistanceSq(p, c))
+			}
+			distances[i] = d
+			total += d
+		}
+		if total == 0 {
+			break
+		}
+		target := rng.Float64() * total
+		next := len(pixels) - 1
+		for i, d := range distances {
+			target -= d
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centers = append(centers, pixels[next])
+	}
+
+	assignments := make([]int, len(pixels))
+	for iteration := 0; iteration < kMeansIterations; iteration++ {
+		changed := false
+		for i, p := range pixels {
+			best, bestDistance := 0, math.MaxFloat64
+			for j, c := range centers {
+				if d := labDistanceSq(p, c); d < bestDistance {
+					best, bestDistance = j, d
+				}
+			}
+			if assignments[i] != best {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed && iteration > 0 {
+			break
+		}
+
+		sums := make([]lab, len(centers))
+		counts := make([]float64, len(centers))
+		for i, p := range pixels {
+			j := assignments[i]
+			sums[j].l += p.l
+			sums[j].a += p.a
+			sums[j].b += p.b
+			counts[j]++
+		}
+		for j := range centers {
+			if counts[j] > 0 {
+				centers[j] = lab{sums[j].l / counts[j], sums[j].a / counts[j], sums[j].b / counts[j]}
+			}
+		}
+	}
+	return assignments
+}
+
+func nearestColorName(c color.RGBA) string {
+	target := toLab(c)
+	best, bestDistance := "", math.MaxFloat64
+	for _, named := range namedColors {
+		if d := labDistanceSq(target, toLab(named.rgb)); d < bestDistance {
+			best, bestDistance = named.name, d
+		}
+	}
+	return best
+}
+
+// toLab converts sRGB to CIE L*a*b* (D65), where distances roughly match
+// perceived color differences
+func toLab(c color.RGBA) lab {
+	linear := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.04045 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 0.008856 {
+			return math.Cbrt(t)
+		}
+		return 7.787*t + 16.0/116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return lab{l: 116*fy - 16, a: 500 * (fx - fy), b: 200 * (fy - fz)}
+}
+
+func labDistanceSq(p, q lab) float64 {
+	dl, da, db := p.l-q.l, p.a-q.a, p.b-q.b
+	return dl*dl + da*da + db*db
+}
+
+func labDistance(p, q lab) float64 {
+	return math.Sqrt(labDistanceSq(p, q))
+}
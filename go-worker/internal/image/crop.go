@@ -0,0 +1,104 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// BBox is a box [x1, y1, x2, y2] in the model's 0-1000 relative
+// coordinates, where [0, 0] is the top-left corner of the image it saw
+type BBox [4]int
+
+// Area returns the fraction of the image the box covers
+func (b BBox) Area() float64 {
+	return float64(b[2]-b[0]) * float64(b[3]-b[1]) / 1e6
+}
+
+// CropPolicy controls the product crop cut from the model's bounding box
+type CropPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxSide caps the longest side of the stored crop
+	MaxSide int `yaml:"max_side"`
+	// Padding widens the box on each side, as a fraction of its size
+	Padding float64 `yaml:"padding"`
+	// MinSide rejects boxes narrower than this in original pixels
+	MinSide int `yaml:"min_side"`
+	// SecondPass re-analyzes the product with the crop added as a detail
+	// view when the box covers less than SecondPassMaxArea of the image
+	SecondPass        bool    `yaml:"second_pass"`
+	SecondPassMaxArea float64 `yaml:"second_pass_max_area"`
+}
+
+// DefaultCropPolicy makes 512px crops padded by 5% from boxes of at least
+// 64px, with a second pass (when enabled) for products covering under half
+// the image
+func DefaultCropPolicy() CropPolicy {
+	return CropPolicy{
+		MaxSide:           512,
+		Padding:           0.05,
+		MinSide:           64,
+		SecondPassMaxArea: 0.5,
+	}
+}
+
+func (p CropPolicy) Validate() error {
+	if p.MaxSide < 1 {
+		return fmt.Errorf("crops max_side must be positive, got %d", p.MaxSide)
+	}
+	if p.Padding < 0 {
+		return fmt.Errorf("crops padding must not be negative, got %g", p.Padding)
+	}
+	return nil
+}
+
+// Crop cuts the box out of the image and returns it as a JPEG thumbnail.
+// The box is relative to the image the model saw, so it is mapped onto the
+// upright, flattened and trimmed image at full resolution.
+func Crop(d *Decoded, box BBox, policy CropPolicy) (*Encoded, error) {
+	for _, v := range box {
+		if v < 0 || v > 1000 {
+			return nil, fmt.Errorf("bounding box %v is outside 0-1000", box)
+		}
+	}
+	if box[0] >= box[2] || box[1] >= box[3] {
+		return nil, fmt.Errorf("bounding box %v is empty", box)
+	}
+
+	interpolator, err := d.policy.interpolator()
+	if err != nil {
+		return nil, err
+	}
+
+	img := d.Image
+	bounds := img.Bounds()
+	width := float64(bounds.Dx())
+	height := float64(bounds.Dy())
+	x1 := float64(box[0]) * width / 1000
+	y1 := float64(box[1]) * height / 1000
+	x2 := float64(box[2]) * width / 1000
+	y2 := float64(box[3]) * height / 1000
+	if x2-x1 < float64(policy.MinSide) || y2-y1 < float64(policy.MinSide) {
+		return nil, fmt.Errorf("bounding box %v is too small (%.0fx%.0f pixels, minimum %d)", box, x2-x1, y2-y1, policy.MinSide)
+	}
+
+	padX := (x2 - x1) * policy.Padding
+	padY := (y2 - y1) * policy.Padding
+	rect := image.Rect(
+		bounds.Min.X+int(math.Floor(x1-padX)),
+		bounds.Min.Y+int(math.Floor(y1-padY)),
+		bounds.Min.X+int(math.Ceil(x2+padX)),
+		bounds.Min.Y+int(math.Ceil(y2+padY)),
+	).Intersect(bounds)
+
+	w := rect.Dx()
+	h := rect.Dy()
+	scale := min(1, float64(policy.MaxSide)/float64(max(w, h)))
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))))
+	interpolator.Scale(dst, dst.Bounds(), img, rect, draw.Src, nil)
+
+	fmt.Printf("Cropped bounding box %v to %dx%d\n", box, dst.Bounds().Dx(), dst.Bounds().Dy())
+	return encode(dst, "jpeg", d.policy.JPEGQuality)
+}
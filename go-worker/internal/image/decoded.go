@@ -0,0 +1,62 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Decoded is an image decoded once per job and shared by every step that
+// reads its pixels: quality, hashing, colors, resizing, tiling and crops
+type Decoded struct {
+	// Data is the image as uploaded
+	Data   []byte
+	Format string
+	// Orientation is the EXIF orientation applied to make it upright
+	Orientation int
+	// Width and Height are the size of the upright upload, before trimming
+	Width, Height int
+	// Image is what the model sees before scaling: upright, flattened and
+	// trimmed per the resize policy
+	Image     image.Image
+	Flattened bool
+	Trimmed   bool
+
+	policy ResizePolicy
+	// upright is the image before flattening and trimming
+	upright image.Image
+	// border is the background color found before trimming, if any
+	border    color.RGBA
+	hasBorder bool
+}
+
+// Decode decodes the image, rotates it upright per its EXIF orientation,
+// flattens any transparency onto the background and trims its borders,
+// as the resize policy asks
+func Decode(data []byte, policy ResizePolicy) (*Decoded, error) {
+	background, err := policy.background()
+	if err != nil {
+		return nil, err
+	}
+
+	img, format, orientation, err := decodeOriented(data, policy.Limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	d := &Decoded{
+		Data:        data,
+		Format:      format,
+		Orientation: orientation,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		policy:      policy,
+		upright:     img,
+	}
+
+	d.Image, d.Flattened = flatten(img, background)
+	// Found before trimming, which may crop most of the background away
+	d.border, d.hasBorder = borderColor(d.Image, policy.Trim.Tolerance)
+	d.Image, d.Trimmed = trim(d.Image, policy.Trim)
+	return d, nil
+}
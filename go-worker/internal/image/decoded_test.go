@@ -0,0 +1,67 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+// productShot is a red square on a wide white margin, as supplier photos are
+func productShot(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(300, 200, 500, 400), &image.Uniform{C: color.RGBA{200, 30, 40, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodedSharedBySteps(t *testing.T) {
+	policy := DefaultResizePolicy()
+	policy.Limits = DefaultLimits()
+	policy.Trim.Enabled = true
+
+	d, err := Decode(productShot(t), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Width != 800 || d.Height != 600 {
+		t.Errorf("upload size %dx%d, want 800x600", d.Width, d.Height)
+	}
+	if !d.Trimmed || d.Image.Bounds().Dx() != 200 {
+		t.Fatalf("trimmed to %v, want the 200px product", d.Image.Bounds())
+	}
+
+	// The background is found before trimming, so only the product counts
+	colors := DominantColors(d, DefaultColorPolicy())
+	if len(colors) != 1 || colors[0].Name != "Red" {
+		t.Errorf("dominant colors %+v, want only red", colors)
+	}
+
+	// Hashes are taken before trimming
+	if Hash(d) == (Hashes{}) {
+		t.Error("hash of the upload is zero")
+	}
+
+	crop, err := Crop(d, BBox{0, 0, 1000, 1000}, CropPolicy{MaxSide: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cropConfig, _, err := image.DecodeConfig(bytes.NewReader(crop.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cropConfig.Width != 200 || cropConfig.Height != 200 {
+		t.Errorf("crop of the whole trimmed image is %dx%d, want 200x200", cropConfig.Width, cropConfig.Height)
+	}
+
+	if _, err := Resize(d); err != nil {
+		t.Errorf("Resize failed: %v", err)
+	}
+}
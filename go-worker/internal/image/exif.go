@@ -0,0 +1,201 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+const orientationTag = 0x0112
+
+// jpegOrientation returns the EXIF Orientation (1-8) of JPEG data, or 1 if
+// there is none
+func jpegOrientation(data []byte) int {
+	for _, segment := range jpegSegments(data) {
+		if segment.marker != 0xE1 || !bytes.HasPrefix(segment.payload, []byte("Exif\x00\x00")) {
+			continue
+		}
+		if orientation := tiffOrientation(segment.payload[6:]); orientation >= 1 && orientation <= 8 {
+			return orientation
+		}
+	}
+	return 1
+}
+
+// tiffOrientation reads the Orientation tag from IFD0 of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == orientationTag {
+			// SHORT value stored in the first two bytes of the value field
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 0
+}
+
+type jpegSegment struct {
+	marker  byte
+	start   int // offset of the 0xFF marker byte
+	end     int // offset just past the segment
+	payload []byte
+}
+
+// jpegSegments lists the marker segments before the image data (SOS)
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{
+			marker:  marker,
+			start:   pos,
+			end:     pos + 2 + length,
+			payload: data[pos+4 : pos+2+length],
+		})
+		pos += 2 + length
+	}
+	return segments
+}
+
+// stripJPEGMetadata removes the APP1 (EXIF, XMP) and APP13 (IPTC) segments,
+// which carry camera details and GPS location, without re-encoding. It
+// returns nil if there was nothing to strip.
+func stripJPEGMetadata(data []byte) []byte {
+	var stripped []byte
+	last := 0
+	for _, segment := range jpegSegments(data) {
+		if segment.marker != 0xE1 && segment.marker != 0xED {
+			continue
+		}
+		stripped = append(stripped, data[last:segment.start]...)
+		last = segment.end
+	}
+	if last == 0 {
+		return nil
+	}
+	return append(stripped, data[last:]...)
+}
+
+// pngMetadataChunks are the ancillary PNG chunks that can carry EXIF
+// (camera details, GPS location), free text or a timestamp
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata removes the metadata chunks from PNG data without
+// re-encoding. It returns nil if there was nothing to strip or the chunk
+// structure is malformed.
+func stripPNGMetadata(data []byte) []byte {
+	const signatureLength = 8
+	if len(data) < signatureLength || string(data[:signatureLength]) != "\x89PNG\r\n\x1a\n" {
+		return nil
+	}
+
+	var stripped []byte
+	last := 0
+	pos := signatureLength
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if pngMetadataChunks[chunkType] {
+			stripped = append(stripped, data[last:pos]...)
+			last = end
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	if last == 0 {
+		return nil
+	}
+	return append(stripped, data[last:]...)
+}
+
+// applyOrientation rotates and flips img so it displays upright for the
+// given EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		// Orientations 5-8 swap width and height
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontal
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertical
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90 counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// A 3x2 image with red at the top-left and green next to it
+	const w, h = 3, 2
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, green)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		red, green  image.Point
+	}{
+		{1, image.Pt(w, h), image.Pt(0, 0), image.Pt(1, 0)},
+		{2, image.Pt(w, h), image.Pt(w-1, 0), image.Pt(w-2, 0)},
+		{3, image.Pt(w, h), image.Pt(w-1, h-1), image.Pt(w-2, h-1)},
+		{4, image.Pt(w, h), image.Pt(0, h-1), image.Pt(1, h-1)},
+		{5, image.Pt(h, w), image.Pt(0, 0), image.Pt(0, 1)},
+		{6, image.Pt(h, w), image.Pt(h-1, 0), image.Pt(h-1, 1)},
+		{7, image.Pt(h, w), image.Pt(h-1, w-1), image.Pt(h-1, w-2)},
+		{8, image.Pt(h, w), image.Pt(0, w-1), image.Pt(0, w-2)},
+	}
+
+	for _, tt := range tests {
+		dst := applyOrientation(src, tt.orientation)
+		if size := dst.Bounds().Size(); size != tt.size {
+			t.Errorf("orientation %d: size %v, want %v", tt.orientation, size, tt.size)
+			continue
+		}
+		if got := color.RGBAModel.Convert(dst.At(tt.red.X, tt.red.Y)); got != red {
+			t.Errorf("orientation %d: pixel at %v is %v, want red", tt.orientation, tt.red, got)
+		}
+		if got := color.RGBAModel.Convert(dst.At(tt.green.X, tt.green.Y)); got != green {
+			t.Errorf("orientation %d: pixel at %v is %v, want green", tt.orientation, tt.green, got)
+		}
+	}
+}
+
+// pngWithChunks encodes a small PNG and inserts the chunks after IHDR
+func pngWithChunks(t *testing.T, chunks map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Signature (8) and IHDR (4 length, 4 type, 13 data, 4 CRC)
+	const ihdrEnd = 8 + 25
+	out := append([]byte{}, data[:ihdrEnd]...)
+	for chunkType, payload := range chunks {
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+		chunk = append(chunk, chunkType...)
+		chunk = append(chunk, payload...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+		out = append(out, chunk...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	data := pngWithChunks(t, map[string]string{
+		"tEXt": "Comment\x00taken at home",
+		"eXIf": "MM\x00\x2a\x00\x00\x00\x08\x00\x00",
+	})
+
+	stripped := stripPNGMetadata(data)
+	if stripped == nil {
+		t.Fatal("stripPNGMetadata found nothing to strip")
+	}
+	for _, chunkType := range []string{"tEXt", "eXIf"} {
+		if bytes.Contains(stripped, []byte(chunkType)) {
+			t.Errorf("%s chunk was kept", chunkType)
+		}
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped PNG doesn't decode: %v", err)
+	}
+
+	if stripPNGMetadata(pngWithChunks(t, nil)) != nil {
+		t.Error("stripPNGMetadata changed a PNG without metadata")
+	}
+}
+
+func TestResizeStripsPNGMetadata(t *testing.T) {
+	data := pngWithChunks(t, map[string]string{"tEXt": "GPS\x0052.37,4.89"})
+
+	policy := DefaultResizePolicy()
+	policy.Limits = DefaultLimits()
+	decoded, err := Decode(data, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := Resize(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.MIMEType != "image/png" {
+		t.Fatalf("MIMEType = %s, want image/png", encoded.MIMEType)
+	}
+	if bytes.Contains(encoded.Data, []byte("52.37")) {
+		t.Error("PNG text metadata was sent to the model")
+	}
+}
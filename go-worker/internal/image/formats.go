@@ -0,0 +1,134 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// maxScoredFrames bounds how many frames of an animated GIF are scored
+// when picking a representative one
+const maxScoredFrames = 32
+
+// decodeImage decodes image data by sniffing its content, never its file
+// extension, refusing images over the pixel limit. Animated GIFs decode to
+// their most detailed frame.
+func decodeImage(data []byte, limits Limits) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", fmt.Errorf("unsupported image format (detected %s)", http.DetectContentType(data))
+		}
+		return nil, "", err
+	}
+
+	// Check the declared dimensions before allocating any pixels
+	if err := checkPixels(data, format, config.Width, config.Height, limits); err != nil {
+		return nil, "", err
+	}
+
+	if format == "gif" {
+		img, err := decodeGIF(data)
+		return img, format, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	return img, format, nil
+}
+
+// decodeGIF composites the frames of a GIF and returns the frame with the
+// most detail, skipping the blank or faded intro frames common in animations
+func decodeGIF(data []byte) (image.Image, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) == 1 {
+		return g.Image[0], nil
+	}
+
+	fmt.Printf("Animated GIF with %d frames\n", len(g.Image))
+
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(screen)
+	step := (len(g.Image) + maxScoredFrames - 1) / maxScoredFrames
+
+	var best *image.RGBA
+	bestScore := -1.0
+	bestIndex := 0
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		if i%step == 0 {
+			if score := detailScore(canvas); score > bestScore {
+				best = cloneRGBA(canvas)
+				bestScore = score
+				bestIndex = i
+			}
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	fmt.Printf("Using GIF frame %d as representative\n", bestIndex)
+	return best, nil
+}
+
+// detailScore is the luminance variance over a sample grid of opaque pixels
+func detailScore(img *image.RGBA) float64 {
+	bounds := img.Bounds()
+	stride := max(1, max(bounds.Dx(), bounds.Dy())/64)
+
+	var sum, sumSq, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stride {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stride {
+			c := img.RGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			l := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
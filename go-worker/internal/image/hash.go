@@ -0,0 +1,99 @@
+package image
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Hashes are 64-bit perceptual hashes of an image. Near-identical images
+// (re-encoded, resized, lightly edited) differ in only a few bits.
+type Hashes struct {
+	DHash uint64
+	PHash uint64
+}
+
+// Hash hashes the upright image, before flattening and trimming, so
+// rotated re-uploads still match
+func Hash(d *Decoded) Hashes {
+	return Hashes{DHash: DHash(d.upright), PHash: PHash(d.upright)}
+}
+
+// DHash compares each pixel with its right neighbour on a 9x8 grayscale
+// thumbnail, one bit per comparison
+func DHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y*9+x] < pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash takes the 2D DCT of a 32x32 grayscale thumbnail and sets one bit
+// per low-frequency coefficient (top-left 8x8, skipping the DC term) that
+// is above the median
+func PHash(img image.Image) uint64 {
+	const size = 32
+	pixels := grayscale(img, size, size)
+
+	// Separable DCT-II: rows, then columns of the 8 lowest frequencies
+	rows := make([]float64, size*8)
+	for y := 0; y < size; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pixels[y*size+x] * dctCos(x, u, size)
+			}
+			rows[y*8+u] = sum
+		}
+	}
+
+	coefficients := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y*8+u] * dctCos(y, v, size)
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, coefficient := range coefficients {
+		hash <<= 1
+		if i > 0 && coefficient > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+func dctCos(x, u, size int) float64 {
+	return math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*size))
+}
+
+// grayscale scales the image to width x height and returns its luma values
+func grayscale(img image.Image, width, height int) []float64 {
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	pixels := make([]float64, width*height)
+	for i := range pixels {
+		pixels[i] = float64(dst.Pix[(i/width)*dst.Stride+i%width])
+	}
+	return pixels
+}
@@ -0,0 +1,129 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Limits bound the inputs the worker will decode, so a decompression bomb
+// (a small file declaring huge dimensions) is rejected instead of
+// exhausting memory. Zero means no limit.
+type Limits struct {
+	// MaxFileBytes is enforced by the image sources as they fetch
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+	// MaxPixels caps width x height, summed over the frames of a GIF
+	MaxPixels int64 `yaml:"max_pixels"`
+}
+
+// DefaultLimits allows 50 MB files and 64 megapixels, enough for
+// full-resolution phone photos
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileBytes: 50 << 20,
+		MaxPixels:    64 << 20,
+	}
+}
+
+// checkPixels rejects images whose declared dimensions exceed the limit
+func checkPixels(data []byte, format string, width int, height int, limits Limits) error {
+	if limits.MaxPixels <= 0 {
+		return nil
+	}
+
+	pixels := int64(width) * int64(height)
+	if pixels > limits.MaxPixels {
+		return fmt.Errorf("image is too large (%dx%d, limit %d pixels)", width, height, limits.MaxPixels)
+	}
+
+	if format == "gif" {
+		frames, err := countGIFFrames(data)
+		if err != nil {
+			return fmt.Errorf("failed to read GIF: %w", err)
+		}
+		if pixels*int64(frames) > limits.MaxPixels {
+			return fmt.Errorf("animated GIF is too large (%d frames of %dx%d, limit %d pixels)", frames, width, height, limits.MaxPixels)
+		}
+	}
+	return nil
+}
+
+// countGIFFrames walks the GIF block structure, skipping image data, to
+// count frames without decoding them
+func countGIFFrames(data []byte) (int, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	// Header and logical screen descriptor
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if header[10]&0x80 != 0 {
+		if err := skip(r, colorTableSize(header[10])); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch introducer {
+		case 0x21: // Extension: label, then sub-blocks
+			if _, err := r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return 0, err
+			}
+		case 0x2C: // Image descriptor, local color table, LZW code size, sub-blocks
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return 0, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if err := skip(r, colorTableSize(descriptor[8])); err != nil {
+					return 0, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3B: // Trailer
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("unknown block 0x%02x", introducer)
+		}
+	}
+}
+
+func colorTableSize(flags byte) int {
+	return 3 * (1 << (flags&0x07 + 1))
+}
+
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if err := skip(r, int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+func skip(r *bufio.Reader, n int) error {
+	_, err := r.Discard(n)
+	return err
+}
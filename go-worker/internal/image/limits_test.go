@@ -0,0 +1,59 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames int, width int, height int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	for _, frames := range []int{1, 2, 7} {
+		got, err := countGIFFrames(encodeGIF(t, frames, 4, 3))
+		if err != nil {
+			t.Fatalf("countGIFFrames of %d frames failed: %v", frames, err)
+		}
+		if got != frames {
+			t.Errorf("countGIFFrames = %d, want %d", got, frames)
+		}
+	}
+}
+
+func TestCountGIFFramesTruncated(t *testing.T) {
+	data := encodeGIF(t, 3, 4, 3)
+	if _, err := countGIFFrames(data[:len(data)-10]); err == nil {
+		t.Error("countGIFFrames of a truncated GIF succeeded")
+	}
+}
+
+func TestCheckPixelsGIF(t *testing.T) {
+	data := encodeGIF(t, 5, 10, 10)
+
+	if err := checkPixels(data, "gif", 10, 10, Limits{MaxPixels: 500}); err != nil {
+		t.Errorf("5 frames of 100 pixels rejected at a 500 pixel limit: %v", err)
+	}
+	err := checkPixels(data, "gif", 10, 10, Limits{MaxPixels: 499})
+	if err == nil || !strings.Contains(err.Error(), "5 frames") {
+		t.Errorf("5 frames of 100 pixels at a 499 pixel limit = %v, want a frame count error", err)
+	}
+	if err := checkPixels(data, "gif", 10, 10, Limits{}); err != nil {
+		t.Errorf("zero limit rejected the GIF: %v", err)
+	}
+}
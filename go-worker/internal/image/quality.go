@@ -0,0 +1,137 @@
+package image
+
+import (
+	"fmt"
+	"math"
+)
+
+// qualityWorkingSide is the shortest side images are scaled to before
+// scoring, so sharpness thresholds don't depend on the upload's resolution
+const qualityWorkingSide = 512
+
+// QualityPolicy rejects images the model can't say anything useful about
+type QualityPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSide is the smallest acceptable shortest side, in pixels
+	MinSide int `yaml:"min_side"`
+	// MinSharpness is the smallest acceptable Laplacian variance
+	MinSharpness float64 `yaml:"min_sharpness"`
+	// MinContrast is the smallest acceptable luminance standard deviation;
+	// below it the image is blank or nearly a single color
+	MinContrast float64 `yaml:"min_contrast"`
+	// MinBrightness and MaxBrightness bound the mean luminance (0-255)
+	MinBrightness float64 `yaml:"min_brightness"`
+	MaxBrightness float64 `yaml:"max_brightness"`
+}
+
+// DefaultQualityPolicy has deliberately lenient thresholds: white-background
+// product shots are mostly flat, bright and low in Laplacian variance even
+// when sharp. A zero threshold turns that check off.
+func DefaultQualityPolicy() QualityPolicy {
+	return QualityPolicy{
+		MinSide:       256,
+		MinSharpness:  15,
+		MinContrast:   4,
+		MinBrightness: 30,
+		MaxBrightness: 250,
+	}
+}
+
+// Quality holds an image's scores, recorded whether or not it passed
+type Quality struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Sharpness is the Laplacian variance; blurry images score low
+	Sharpness float64 `json:"sharpness"`
+	// Contrast is the luminance standard deviation
+	Contrast float64 `json:"contrast"`
+	// Brightness is the mean luminance
+	Brightness float64 `json:"brightness"`
+	// DarkFraction and BrightFraction are the shares of clipped pixels
+	DarkFraction   float64 `json:"dark_fraction"`
+	BrightFraction float64 `json:"bright_fraction"`
+}
+
+// QualityError is a failed quality check, with a message meant for the
+// person who uploaded the image
+type QualityError struct {
+	Message string
+}
+
+func (e *QualityError) Error() string {
+	return e.Message
+}
+
+// CheckQuality scores the image as it will be analyzed (upright, flattened
+// and trimmed per the resize policy). It returns a *QualityError if the
+// image fails the policy.
+func CheckQuality(d *Decoded, policy QualityPolicy) (Quality, error) {
+	// Resolution is judged on the upload, the other scores on what the
+	// model will see
+	quality := Quality{Width: d.Width, Height: d.Height}
+	img := d.Image
+
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	// Score at a fixed working size, never upscaling
+	scale := min(1, float64(qualityWorkingSide)/float64(min(width, height)))
+	w := max(3, int(float64(width)*scale))
+	h := max(3, int(float64(height)*scale))
+	pixels := grayscale(img, w, h)
+
+	var histogram [256]int
+	for _, v := range pixels {
+		histogram[int(math.Min(math.Max(v, 0), 255))]++
+	}
+	var sum, sumSq float64
+	for level, count := range histogram {
+		sum += float64(level * count)
+		sumSq += float64(level*level) * float64(count)
+	}
+	n := float64(len(pixels))
+	quality.Brightness = sum / n
+	quality.Contrast = math.Sqrt(math.Max(0, sumSq/n-quality.Brightness*quality.Brightness))
+	for level := 0; level < 16; level++ {
+		quality.DarkFraction += float64(histogram[level]) / n
+		quality.BrightFraction += float64(histogram[255-level]) / n
+	}
+	quality.Sharpness = laplacianVariance(pixels, w, h)
+
+	switch {
+	case min(quality.Width, quality.Height) < policy.MinSide:
+		return quality, &QualityError{Message: fmt.Sprintf(
+			"Image is too small (%dx%d). Upload a photo at least %d pixels on its shortest side.",
+			quality.Width, quality.Height, policy.MinSide)}
+	case quality.Contrast < policy.MinContrast:
+		return quality, &QualityError{Message: "Image is blank or almost a single color. Upload a photo that shows the product."}
+	case quality.Brightness < policy.MinBrightness:
+		return quality, &QualityError{Message: "Image is too dark to analyze. Upload a brighter photo."}
+	case policy.MaxBrightness > 0 && quality.Brightness > policy.MaxBrightness:
+		return quality, &QualityError{Message: "Image is too overexposed to analyze. Upload a photo with softer lighting."}
+	case quality.Sharpness < policy.MinSharpness:
+		return quality, &QualityError{Message: "Image is too blurry to analyze. Upload a sharper, in-focus photo."}
+	}
+	return quality, nil
+}
+
+// laplacianVariance convolves the grayscale pixels with a 3x3 Laplacian
+// and returns the variance of the response; edges in sharp images give a
+// wide spread, blur flattens it
+func laplacianVariance(pixels []float64, width, height int) float64 {
+	var sum, sumSq, n float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			v := pixels[i-width] + pixels[i+width] + pixels[i-1] + pixels[i+1] - 4*pixels[i]
+			sum += v
+			sumSq += v * v
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
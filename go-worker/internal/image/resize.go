@@ -1,53 +1,77 @@
 package image
 
 import (
+	"bytes"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
-	"os"
-	"path/filepath"
-	"strings"
+	"math"
 
 	"golang.org/x/image/draw"
 )
 
-func ResizeToMinDimension(imagePath string, targetSize int) (string, error) {
-	// Open the original image
-	file, err := os.Open(imagePath)
+// Encoded is an image encoded in memory, ready to send to the model
+type Encoded struct {
+	Data     []byte
+	MIMEType string
+}
+
+// Resize scales the image per its resize policy and returns it encoded,
+// without writing anything to disk
+func Resize(d *Decoded) (*Encoded, error) {
+	policy := d.policy
+	interpolator, err := policy.interpolator()
 	if err != nil {
-		return "", fmt.Errorf("failed to open image: %w", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	// Decode the image
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode image: %w", err)
+	fmt.Printf("Original image size: %dx%d (format: %s)\n", d.Width, d.Height, d.Format)
+	if d.Orientation > 1 {
+		fmt.Printf("Applied EXIF orientation %d\n", d.Orientation)
+	}
+	if d.Flattened {
+		fmt.Printf("Flattened transparency onto %s\n", policy.Background)
 	}
 
+	img := d.Image
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
-
-	fmt.Printf("Original image size: %dx%d (format: %s)\n", width, height, format)
-
-	// Calculate new dimensions
-	var newWidth, newHeight int
-	if width < height {
-		// Width is shorter
-		newWidth = targetSize
-		newHeight = int(float64(height) * float64(targetSize) / float64(width))
-	} else {
-		// Height is shorter (or equal)
-		newHeight = targetSize
-		newWidth = int(float64(width) * float64(targetSize) / float64(height))
+	if d.Trimmed {
+		fmt.Printf("Trimmed borders to %dx%d\n", width, height)
 	}
 
 	// Skip resize if image is already small enough
-	if (width <= newWidth && height <= newHeight) || (width < targetSize && height < targetSize) {
+	scale := policy.scale(width, height)
+	newWidth := max(1, int(math.Round(float64(width)*scale)))
+	newHeight := max(1, int(math.Round(float64(height)*scale)))
+	if newWidth >= width && newHeight >= height {
 		fmt.Printf("Image is already small enough (%dx%d), skipping resize\n", width, height)
-		return imagePath, nil
+
+		if d.Orientation > 1 || d.Flattened || d.Trimmed {
+			// Pixels were rotated, composited or cropped, so the image has
+			// to be re-encoded anyway
+			return encode(img, d.Format, policy.JPEGQuality)
+		}
+
+		// The model server only reads JPEG and PNG, and a GIF may have
+		// been decoded to a later frame than its first
+		if d.Format != "jpeg" && d.Format != "png" {
+			return encode(img, d.Format, policy.JPEGQuality)
+		}
+
+		// Never send EXIF (camera details, GPS location) to the model
+		data := d.Data
+		stripped := stripJPEGMetadata(data)
+		if d.Format == "png" {
+			stripped = stripPNGMetadata(data)
+		}
+		if stripped != nil {
+			data = stripped
+		}
+		return &Encoded{Data: data, MIMEType: "image/" + d.Format}, nil
 	}
 
 	fmt.Printf("Resizing to: %dx%d\n", newWidth, newHeight)
@@ -55,36 +79,65 @@ func ResizeToMinDimension(imagePath string, targetSize int) (string, error) {
 	// Create new image with target dimensions
 	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
 
-	// Use high-quality bilinear interpolation
-	draw.BiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	interpolator.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
 
-	// Create temporary file for resized image
-	tmpFile, err := os.CreateTemp("", "resized-*.jpg")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
+	return encode(dst, d.Format, policy.JPEGQuality)
+}
 
-	// Encode based on original format, but prefer JPEG for compatibility
-	ext := strings.ToLower(filepath.Ext(imagePath))
-	if ext == ".png" {
-		err = png.Encode(tmpFile, dst)
+// encode re-encodes img, keeping PNG as PNG but preferring JPEG for
+// compatibility. Re-encoding drops all metadata, including EXIF.
+func encode(img image.Image, format string, quality int) (*Encoded, error) {
+	var buf bytes.Buffer
+	var err error
+
+	mimeType := "image/png"
+	if format == "png" {
+		err = png.Encode(&buf, img)
 	} else {
-		// Use JPEG for all other formats (jpg, jpeg, webp, etc.)
-		err = jpeg.Encode(tmpFile, dst, &jpeg.Options{Quality: 90})
+		// Use JPEG for all other formats (jpeg, webp, gif, bmp, tiff)
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	}
 
 	if err != nil {
-		tmpFile.Close()
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to encode resized image: %w", err)
+		return nil, fmt.Errorf("failed to encode resized image: %w", err)
+	}
+
+	fmt.Printf("Encoded image: %d bytes (%s)\n", buf.Len(), mimeType)
+	return &Encoded{Data: buf.Bytes(), MIMEType: mimeType}, nil
+}
+
+// flatten composites an image with transparency onto the background color.
+// It returns the image unchanged, and false, if it is already opaque.
+func flatten(img image.Image, background color.Color) (image.Image, bool) {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img, false
 	}
 
-	if err := tmpFile.Close(); err != nil {
-		os.Remove(tmpPath)
-		return "", fmt.Errorf("failed to close temp file: %w", err)
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, &image.Uniform{C: background}, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst, true
+}
+
+// decodeOriented decodes image data and applies the JPEG or TIFF EXIF
+// orientation
+func decodeOriented(data []byte, limits Limits) (image.Image, string, int, error) {
+	img, format, err := decodeImage(data, limits)
+	if err != nil {
+		return nil, "", 0, err
 	}
 
-	fmt.Printf("Resized image saved to: %s\n", tmpPath)
-	return tmpPath, nil
+	orientation := 1
+	switch format {
+	case "jpeg":
+		orientation = jpegOrientation(data)
+	case "tiff":
+		if o := tiffOrientation(data); o >= 1 && o <= 8 {
+			orientation = o
+		}
+	}
+	img = applyOrientation(img, orientation)
+	return img, format, orientation, nil
 }
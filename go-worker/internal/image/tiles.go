@@ -0,0 +1,149 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// minTileGain is how much more resolution a tile must show than the
+// overview to be worth its tokens
+const minTileGain = 1.25
+
+// TilePolicy sends high-resolution crops of the image alongside the usual
+// downscaled overview, so small details (care labels, logos, texture)
+// survive
+type TilePolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// Grid is the most tiles per side; a coarser grid is used when the
+	// tiles don't fit the context
+	Grid int `yaml:"grid"`
+	// Overlap extends each tile into its neighbours, as a fraction of its
+	// size, so details on a seam appear whole in one tile
+	Overlap float64 `yaml:"overlap"`
+	// MaxSide caps the longest side of each tile
+	MaxSide int `yaml:"max_side"`
+}
+
+// DefaultTilePolicy allows up to a 2x2 grid of 1024px tiles with 10%
+// overlap
+func DefaultTilePolicy() TilePolicy {
+	return TilePolicy{Grid: 2, Overlap: 0.1, MaxSide: 1024}
+}
+
+func (p TilePolicy) Validate() error {
+	if p.MaxSide < 1 {
+		return fmt.Errorf("tiles max_side must be positive, got %d", p.MaxSide)
+	}
+	if p.Overlap < 0 || p.Overlap > 1 {
+		return fmt.Errorf("tiles overlap must be between 0 and 1, got %g", p.Overlap)
+	}
+	return nil
+}
+
+func (p TilePolicy) String() string {
+	if !p.Enabled {
+		return "off"
+	}
+	return fmt.Sprintf("grid=%d overlap=%g max_side=%d", p.Grid, p.Overlap, p.MaxSide)
+}
+
+// Tiled is an overview of the whole image followed by tiles of a Grid x
+// Grid layout in reading order
+type Tiled struct {
+	Overview *Encoded
+	Tiles    []*Encoded
+	Grid     int
+}
+
+// Tile returns the image resized as usual plus the finest grid of tiles
+// (up to policy.Grid per side) whose pixels, with the overview's, fit in
+// maxPixels. No tiles are returned when even a 2x2 grid doesn't fit or
+// wouldn't show more detail than the overview.
+func Tile(d *Decoded, policy TilePolicy, maxPixels int) (*Tiled, error) {
+	overview, err := Resize(d)
+	if err != nil {
+		return nil, err
+	}
+	tiled := &Tiled{Overview: overview}
+
+	overviewConfig, _, err := image.DecodeConfig(bytes.NewReader(overview.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overview size: %w", err)
+	}
+	overviewPixels := overviewConfig.Width * overviewConfig.Height
+
+	interpolator, err := d.policy.interpolator()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tiles are cut from the image the overview shows
+	img := d.Image
+	bounds := img.Bounds()
+	overviewScale := float64(overviewConfig.Width) / float64(bounds.Dx())
+
+	for grid := policy.Grid; grid >= 2; grid-- {
+		rects := tileRects(bounds, grid, policy.Overlap)
+
+		pixels := overviewPixels
+		gain := math.MaxFloat64
+		sizes := make([]image.Point, len(rects))
+		for i, rect := range rects {
+			scale := min(1, float64(policy.MaxSide)/float64(max(rect.Dx(), rect.Dy())))
+			sizes[i] = image.Pt(max(1, int(math.Round(float64(rect.Dx())*scale))), max(1, int(math.Round(float64(rect.Dy())*scale))))
+			pixels += sizes[i].X * sizes[i].Y
+			gain = min(gain, scale/overviewScale)
+		}
+
+		if gain < minTileGain {
+			// Coarser grids show even less detail
+			break
+		}
+		if pixels > maxPixels {
+			continue
+		}
+
+		for i, rect := range rects {
+			dst := image.NewRGBA(image.Rectangle{Max: sizes[i]})
+			interpolator.Scale(dst, dst.Bounds(), img, rect, draw.Src, nil)
+			encoded, err := encode(dst, "jpeg", d.policy.JPEGQuality)
+			if err != nil {
+				return nil, err
+			}
+			tiled.Tiles = append(tiled.Tiles, encoded)
+		}
+		tiled.Grid = grid
+		fmt.Printf("Split image into %dx%d tiles at %.1fx the overview resolution\n", grid, grid, gain)
+		return tiled, nil
+	}
+
+	fmt.Printf("No tile grid fits the context (%d pixels available) and adds detail, sending the overview only\n", maxPixels)
+	return tiled, nil
+}
+
+// tileRects splits bounds into a grid x grid layout in reading order, each
+// tile extended by overlap into its neighbours
+func tileRects(bounds image.Rectangle, grid int, overlap float64) []image.Rectangle {
+	w := float64(bounds.Dx()) / float64(grid)
+	h := float64(bounds.Dy()) / float64(grid)
+	padX := w * overlap / 2
+	padY := h * overlap / 2
+
+	rects := make([]image.Rectangle, 0, grid*grid)
+	for row := 0; row < grid; row++ {
+		for col := 0; col < grid; col++ {
+			rect := image.Rect(
+				bounds.Min.X+int(math.Floor(float64(col)*w-padX)),
+				bounds.Min.Y+int(math.Floor(float64(row)*h-padY)),
+				bounds.Min.X+int(math.Ceil(float64(col+1)*w+padX)),
+				bounds.Min.Y+int(math.Ceil(float64(row+1)*h+padY)),
+			).Intersect(bounds)
+			rects = append(rects, rect)
+		}
+	}
+	return rects
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// maxNoiseFraction is the share of pixels in a border row or column that
+// may differ from the border color, for dust, JPEG noise and faint shadows
+const maxNoiseFraction = 0.005
+
+// TrimPolicy crops uniform borders (the wide white margins of supplier
+// photos) so more of the resize budget lands on the product
+type TrimPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// Tolerance is how far (0-255, per channel) a pixel may be from the
+	// border color and still count as border
+	Tolerance int `yaml:"tolerance"`
+	// Padding is kept around the content, as a fraction of its longest side
+	Padding float64 `yaml:"padding"`
+}
+
+func (p TrimPolicy) String() string {
+	if !p.Enabled {
+		return "off"
+	}
+	return fmt.Sprintf("tolerance=%d padding=%g", p.Tolerance, p.Padding)
+}
+
+// trim crops img to its content bounding box plus padding. It returns the
+// image unchanged, and false, if there is no uniform border to remove.
+func trim(img image.Image, policy TrimPolicy) (image.Image, bool) {
+	if !policy.Enabled {
+		return img, false
+	}
+
+	bounds := img.Bounds()
+	border, ok := borderColor(img, policy.Tolerance)
+	if !ok {
+		return img, false
+	}
+
+	isBorder := func(x, y int) bool {
+		return colorDistance(rgbAt(img, x, y), border) <= policy.Tolerance
+	}
+	rowIsBorder := func(y int) bool {
+		noise := 0
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isBorder(x, y) {
+				noise++
+			}
+		}
+		return float64(noise) <= maxNoiseFraction*float64(bounds.Dx())
+	}
+
+	top, bottom := bounds.Min.Y, bounds.Max.Y
+	for top < bottom && rowIsBorder(top) {
+		top++
+	}
+	for bottom > top && rowIsBorder(bottom-1) {
+		bottom--
+	}
+	if top >= bottom {
+		// Uniform image, nothing to focus on
+		return img, false
+	}
+
+	colIsBorder := func(x int) bool {
+		noise := 0
+		for y := top; y < bottom; y++ {
+			if !isBorder(x, y) {
+				noise++
+			}
+		}
+		return float64(noise) <= maxNoiseFraction*float64(bottom-top)
+	}
+
+	left, right := bounds.Min.X, bounds.Max.X
+	for left < right && colIsBorder(left) {
+		left++
+	}
+	for right > left && colIsBorder(right-1) {
+		right--
+	}
+
+	content := image.Rect(left, top, right, bottom)
+	pad := int(policy.Padding * float64(max(content.Dx(), content.Dy())))
+	content = image.Rect(left-pad, top-pad, right+pad, bottom+pad).Intersect(bounds)
+	if content.Eq(bounds) {
+		return img, false
+	}
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(content), true
+	}
+	dst := image.NewRGBA(content)
+	draw.Draw(dst, content, img, content.Min, draw.Src)
+	return dst, true
+}
+
+// borderColor returns the color shared by at least three of the four
+// corners, so a product or shadow touching one corner doesn't prevent
+// trimming the rest
+func borderColor(img image.Image, tolerance int) (color.RGBA, bool) {
+	bounds := img.Bounds()
+	corners := []color.RGBA{
+		rgbAt(img, bounds.Min.X, bounds.Min.Y),
+		rgbAt(img, bounds.Max.X-1, bounds.Min.Y),
+		rgbAt(img, bounds.Min.X, bounds.Max.Y-1),
+		rgbAt(img, bounds.Max.X-1, bounds.Max.Y-1),
+	}
+
+	for _, candidate := range corners {
+		matches := 0
+		for _, corner := range corners {
+			if colorDistance(candidate, corner) <= tolerance {
+				matches++
+			}
+		}
+		if matches >= 3 {
+			return candidate, true
+		}
+	}
+	return color.RGBA{}, false
+}
+
+func rgbAt(img image.Image, x, y int) color.RGBA {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 255}
+}
+
+// colorDistance is the largest per-channel difference between two colors
+func colorDistance(a, b color.RGBA) int {
+	return max(absDiff(a.R, b.R), absDiff(a.G, b.G), absDiff(a.B, b.B))
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
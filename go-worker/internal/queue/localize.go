@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/ai"
+)
+
+// localizeResult translates the title, description and taxonomy path of an
+// analysis result into each locale, keyed by locale. A failed locale is
+// logged and left out rather than failing the whole job.
+func localizeResult(aiEngine *ai.Engine, result map[string]interface{}, locales []string) map[string]interface{} {
+	title, _ := result["title"].(string)
+	description, _ := result["description"].(string)
+	taxonomy, _ := result["taxonomy"].(string)
+
+	translations := make(map[string]interface{})
+	for _, locale := range locales {
+		locale = strings.TrimSpace(locale)
+		if locale == "" || locale == "en" {
+			continue
+		}
+
+		localized, err := aiEngine.Localize(title, description, taxonomy, locale)
+		if err != nil {
+			log.Printf("Localization to %s failed: %v\n", locale, err)
+			continue
+		}
+
+		var translation map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(localized)), &translation); err != nil {
+			log.Printf("Localization to %s returned invalid JSON: %v\n", locale, err)
+			continue
+		}
+
+		translations[locale] = translation
+	}
+	return translations
+}
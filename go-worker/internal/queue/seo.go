@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	maxTags                  = 10
+	metaDescriptionMaxLength = 160
+	handleMaxLength          = 80
+)
+
+// normalizeSEO tidies the SEO fields of an analysis result in place. The
+// grammar already bounds their shape; this enforces the limits for output
+// from older grammars and removes duplicates.
+func normalizeSEO(data map[string]interface{}) {
+	if rawTags, ok := data["tags"].([]interface{}); ok {
+		data["tags"] = normalizeTags(rawTags)
+	}
+
+	if metaDescription, ok := data["meta_description"].(string); ok {
+		data["meta_description"] = truncateAtWord(strings.TrimSpace(metaDescription), metaDescriptionMaxLength)
+	}
+
+	if altText, ok := data["alt_text"].(string); ok {
+		data["alt_text"] = strings.TrimSpace(altText)
+	}
+
+	if handle, ok := data["handle"].(string); ok {
+		data["handle"] = slugify(handle)
+	}
+}
+
+func normalizeTags(rawTags []interface{}) []string {
+	tags := make([]string, 0, len(rawTags))
+	seen := make(map[string]bool)
+	for _, rawTag := range rawTags {
+		tag, ok := rawTag.(string)
+		if !ok {
+			continue
+		}
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	return tags
+}
+
+// truncateAtWord shortens s to at most limit characters, cutting at the
+// last word boundary when possible
+func truncateAtWord(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+
+	cut := string(runes[:limit])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:-")
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else if (unicode.IsSpace(r) || unicode.IsPunct(r)) && b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+			b.WriteRune('-')
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > handleMaxLength {
+		// Drop the partial word unless the cut falls on a hyphen
+		cut := slug[:handleMaxLength]
+		if slug[handleMaxLength] != '-' {
+			if i := strings.LastIndex(cut, "-"); i > 0 {
+				cut = cut[:i]
+			}
+		}
+		slug = strings.TrimSuffix(cut, "-")
+	}
+	return slug
+}
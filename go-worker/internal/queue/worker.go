@@ -3,6 +3,7 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -10,19 +11,77 @@ import (
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/ai"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/cache"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/copypolicy"
 	"github.com/rivanjarjes/image2taxonomy/worker/internal/db"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/image"
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/storage"
 )
 
+// Upper bound on images per job; each 768px image costs several hundred
+// tokens of the 8192-token context.
+const maxImagesPerJob = 4
+
+// Config holds worker settings loaded from config.yml
+type Config struct {
+	// CopyPolicies by merchant; "default" applies to jobs without a merchant
+	CopyPolicies map[string]*copypolicy.Policy
+	// NearDuplicates reuses earlier analyses of re-uploaded photos
+	NearDuplicates NearDuplicateConfig
+	// ResultCache skips inference for identical images and provenance
+	ResultCache cache.Config
+	// Resize controls how images are scaled before analysis
+	Resize image.ResizePolicy
+	// Quality fails jobs early for images too poor to analyze
+	Quality image.QualityPolicy
+	// Colors measures the product's dominant colors as prompt hints
+	Colors image.ColorPolicy
+	// Tiles sends high-resolution crops alongside single images
+	Tiles image.TilePolicy
+	// Crops cuts the main product out by the model's bounding box
+	Crops image.CropPolicy
+	// Sources fetch job images from local storage roots, HTTP(S) or S3
+	Sources *storage.Sources
+}
+
+type NearDuplicateConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxDistance is the most bits (of 64) either hash may differ by
+	MaxDistance int `yaml:"max_distance"`
+}
+
 type SidekiqJob struct {
 	Class string        `json:"class"`
 	Args  []interface{} `json:"args"`
 	JID   string        `json:"jid"`
 }
 
-func StartWorker(rdb *redis.Client, aiEngine *ai.Engine, dbConn *db.Postgres) {
+// JobOptions is the optional third job argument
+type JobOptions struct {
+	// Mode is "single" (default) for one main product, or "multi" to detect
+	// every product in the image, e.g. to split bundle listings
+	Mode string `json:"mode"`
+	// Locales overrides the configured output locales; an empty list
+	// disables translation for the job
+	Locales []string `json:"locales"`
+	// Merchant selects the copy policy from config.yml
+	Merchant string `json:"merchant"`
+	// Force re-runs the model instead of reusing an earlier or cached result
+	Force bool `json:"force"`
+	// Trim set to false skips border trimming, e.g. for lifestyle photos
+	// where the background matters
+	Trim *bool `json:"trim"`
+	// Tiles overrides whether high-resolution tiles are sent, e.g. true
+	// for products identified by small labels or logos
+	Tiles *bool `json:"tiles"`
+}
+
+func StartWorker(rdb *redis.Client, aiEngine *ai.Engine, dbConn *db.Postgres, cfg Config) {
 	ctx := context.Background()
 	queueName := "queue:default"
 
+	resultCache := cache.NewResults(rdb, cfg.ResultCache)
+
 	fmt.Println("Go Worker Listening on " + queueName)
 
 	for {
@@ -33,11 +92,11 @@ func StartWorker(rdb *redis.Client, aiEngine *ai.Engine, dbConn *db.Postgres) {
 			continue
 		}
 
-		processJob(result[1], aiEngine, dbConn)
+		processJob(result[1], aiEngine, dbConn, resultCache, cfg)
 	}
 }
 
-func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres) {
+func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres, resultCache *cache.Results, cfg Config) {
 	var job SidekiqJob
 	json.Unmarshal([]byte(payload), &job)
 
@@ -51,11 +110,121 @@ func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres) {
 	}
 
 	productID := int(job.Args[0].(float64))
-	imagePath := job.Args[1].(string)
+	imagePaths, err := parseImagePaths(job.Args[1])
+	if err != nil {
+		log.Printf("Invalid job args: %v\n", err)
+		errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
+		dbConn.UpdateStatus(productID, "failed", errorJSON)
+		return
+	}
+
+	if len(imagePaths) > maxImagesPerJob {
+		log.Printf("Job has %d images, only analyzing the first %d\n", len(imagePaths), maxImagesPerJob)
+		imagePaths = imagePaths[:maxImagesPerJob]
+	}
 
-	fmt.Printf("Processing Product ID: %d | Image: %s\n", productID, imagePath)
+	// Fetch each image once. Local paths must be inside the storage roots
+	// and remote hosts or buckets allow-listed; anything else is flagged.
+	uploads := make([][]byte, 0, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		data, err := cfg.Sources.Fetch(imagePath)
+		if err != nil {
+			var securityErr *storage.SecurityError
+			if errors.As(err, &securityErr) {
+				log.Printf("SECURITY: product %d: %v\n", productID, err)
+				dbConn.UpdateStatus(productID, "failed", `{"error_message": "Image path is not allowed", "security_flagged": true}`)
+				return
+			}
+			log.Printf("Image fetch failed: %v\n", err)
+			errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
+			dbConn.UpdateStatus(productID, "failed", errorJSON)
+			return
+		}
+		uploads = append(uploads, data)
+	}
 
-	jsonResult, err := aiEngine.AnalyzeImage(imagePath)
+	opts, err := parseJobOptions(job.Args)
+	if err != nil {
+		log.Printf("Invalid job options: %v\n", err)
+		errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
+		dbConn.UpdateStatus(productID, "failed", errorJSON)
+		return
+	}
+
+	fmt.Printf("Processing Product ID: %d | Images: %s | Mode: %s\n", productID, strings.Join(imagePaths, ", "), opts.Mode)
+
+	resize := cfg.Resize
+	if opts.Trim != nil && !*opts.Trim {
+		resize.Trim.Enabled = false
+	}
+	fmt.Printf("Resize policy: %s\n", resize)
+
+	tiles := cfg.Tiles
+	if opts.Tiles != nil {
+		tiles.Enabled = *opts.Tiles
+	}
+
+	// Decode each image once; every step below reads the same pixels
+	images := make([]*image.Decoded, 0, len(uploads))
+	for i, data := range uploads {
+		decoded, err := image.Decode(data, resize)
+		if err != nil {
+			if len(uploads) > 1 {
+				err = fmt.Errorf("Image %d: %w", i+1, err)
+			}
+			log.Printf("Image decoding failed: %v\n", err)
+			errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
+			dbConn.UpdateStatus(productID, "failed", errorJSON)
+			return
+		}
+		images = append(images, decoded)
+	}
+
+	if cfg.Quality.Enabled {
+		if err := checkQuality(productID, images, cfg.Quality, dbConn); err != nil {
+			log.Printf("Image quality check failed: %v\n", err)
+			errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
+			dbConn.UpdateStatus(productID, "failed", errorJSON)
+			return
+		}
+	}
+
+	// Colors are measured on the first image, which shows the main product
+	var colors []image.DominantColor
+	if cfg.Colors.Enabled && opts.Mode == "single" {
+		colors = dominantColors(productID, images[0], cfg.Colors, dbConn)
+	}
+
+	policy := cfg.CopyPolicies[opts.Merchant]
+	if policy == nil {
+		policy = cfg.CopyPolicies["default"]
+	}
+
+	locales := opts.Locales
+	if locales == nil {
+		locales = aiEngine.Locales()
+	}
+
+	// Near-duplicate reuse only applies to single-image, single-product jobs
+	if len(images) == 1 && opts.Mode == "single" {
+		if reuseNearDuplicate(productID, images[0], opts, policy, locales, dbConn, cfg.NearDuplicates) {
+			return
+		}
+	}
+
+	if opts.Force {
+		// Re-analyze from scratch: skip cache reads but still store the new result
+		resultCache = resultCache.WriteOnly()
+	}
+
+	analyzeOpts := ai.AnalyzeOptions{
+		MultiProduct: opts.Mode == "multi",
+		CopyPolicy:   policy,
+		Resize:       resize,
+		Tiles:        tiles,
+		ColorHints:   colors,
+	}
+	result, err := analyzeWithPolicy(aiEngine, resultCache, images, analyzeOpts)
 	if err != nil {
 		log.Println("AI Failure:", err)
 		errorJSON := fmt.Sprintf(`{"error_message": %q}`, err.Error())
@@ -63,10 +232,41 @@ func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres) {
 		return
 	}
 
-	fmt.Printf("AI Result (raw): %s\n", jsonResult)
+	var crop *image.Encoded
+	if opts.Mode == "single" {
+		var box image.BBox
+		crop, box = cropProduct(productID, images[0], result, cfg.Crops, dbConn)
 
-	// Clean and validate JSON
-	cleanedJSON, err := cleanJSON(jsonResult)
+		// A small product in a busy frame is re-analyzed with its crop as
+		// an extra detail view
+		if crop != nil && cfg.Crops.SecondPass && box.Area() < cfg.Crops.SecondPassMaxArea && len(images) < maxImagesPerJob {
+			fmt.Printf("Product covers %.0f%% of the image, re-analyzing with the crop\n", box.Area()*100)
+			cropImage, err := image.Decode(crop.Data, resize)
+			var detailed map[string]interface{}
+			if err == nil {
+				detailed, err = analyzeWithPolicy(aiEngine, resultCache, append(images[:len(images):len(images)], cropImage), analyzeOpts)
+			}
+			if err != nil {
+				log.Printf("Second pass failed, keeping the first result: %v\n", err)
+			} else {
+				detailed["bbox"] = result["bbox"]
+				result = detailed
+			}
+		}
+	}
+	if crop == nil {
+		// Don't leave the box and crop of an earlier analysis behind
+		if err := dbConn.ClearCrop(productID); err != nil {
+			log.Printf("DB Update Failed: %v\n", err)
+		}
+	}
+
+	if opts.Mode == "single" && len(locales) > 0 {
+		result["translations"] = localizeResult(aiEngine, result, locales)
+	}
+
+	// Re-marshal to compact JSON
+	cleanedJSON, err := json.Marshal(result)
 	if err != nil {
 		log.Printf("JSON cleaning failed: %v\n", err)
 		errorJSON := fmt.Sprintf(`{"error_message": %q}`, fmt.Sprintf("JSON parsing error: %v", err))
@@ -76,7 +276,7 @@ func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres) {
 
 	fmt.Printf("AI Result (cleaned): %s\n", cleanedJSON)
 
-	err = dbConn.UpdateStatus(productID, "complete", cleanedJSON)
+	err = dbConn.UpdateStatus(productID, "complete", string(cleanedJSON))
 	if err != nil {
 		log.Printf("DB Update Failed: %v\n", err)
 		return
@@ -85,21 +285,345 @@ func processJob(payload string, aiEngine *ai.Engine, dbConn *db.Postgres) {
 	fmt.Println("Success! Updated DB.")
 }
 
-func cleanJSON(input string) (string, error) {
+// checkQuality scores every image, records the scores and returns the
+// first failed check, prefixed with the image number when there are several
+func checkQuality(productID int, images []*image.Decoded, policy image.QualityPolicy, dbConn *db.Postgres) error {
+	scores := make([]image.Quality, 0, len(images))
+	var failure error
+	for i, img := range images {
+		quality, err := image.CheckQuality(img, policy)
+		if err != nil {
+			if failure == nil {
+				failure = err
+				if len(images) > 1 {
+					failure = fmt.Errorf("Image %d: %w", i+1, err)
+				}
+			}
+		}
+		fmt.Printf("Image quality: %+v\n", quality)
+		scores = append(scores, quality)
+	}
+
+	scoresJSON, err := json.Marshal(scores)
+	if err == nil {
+		err = dbConn.UpdateImageQuality(productID, string(scoresJSON))
+	}
+	if err != nil {
+		log.Printf("DB Update Failed: %v\n", err)
+	}
+
+	return failure
+}
+
+// dominantColors measures and records the product's dominant colors. A
+// failure only loses the hints, so it is logged rather than failing the job.
+func dominantColors(productID int, img *image.Decoded, policy image.ColorPolicy, dbConn *db.Postgres) []image.DominantColor {
+	colors := image.DominantColors(img, policy)
+	fmt.Printf("Dominant colors: %+v\n", colors)
+
+	if colors == nil {
+		colors = []image.DominantColor{}
+	}
+	colorsJSON, err := json.Marshal(colors)
+	if err == nil {
+		err = dbConn.UpdateDominantColors(productID, string(colorsJSON))
+	}
+	if err != nil {
+		log.Printf("DB Update Failed: %v\n", err)
+	}
+	return colors
+}
+
+// cropProduct validates the model's bounding box against the image and
+// stores a crop of the main product. An invalid box is dropped from the
+// result rather than failing the job.
+func cropProduct(productID int, img *image.Decoded, result map[string]interface{}, policy image.CropPolicy, dbConn *db.Postgres) (*image.Encoded, image.BBox) {
+	raw, ok := result["bbox"]
+	if !ok {
+		return nil, image.BBox{}
+	}
+	if !policy.Enabled {
+		delete(result, "bbox")
+		return nil, image.BBox{}
+	}
+
+	box, err := parseBBox(raw)
+	var crop *image.Encoded
+	if err == nil {
+		crop, err = image.Crop(img, box, policy)
+	}
+	if err != nil {
+		log.Printf("Invalid bounding box: %v\n", err)
+		delete(result, "bbox")
+		return nil, image.BBox{}
+	}
+
+	if err := dbConn.UpdateCrop(productID, crop.Data, crop.MIMEType); err != nil {
+		log.Printf("DB Update Failed: %v\n", err)
+	}
+	return crop, box
+}
+
+func parseBBox(raw interface{}) (image.BBox, error) {
+	var box image.BBox
+	values, ok := raw.([]interface{})
+	if !ok || len(values) != len(box) {
+		return box, fmt.Errorf("bounding box must be [x1, y1, x2, y2], got %v", raw)
+	}
+	for i, v := range values {
+		f, ok := v.(float64)
+		if !ok {
+			return box, fmt.Errorf("bounding box must be [x1, y1, x2, y2], got %v", raw)
+		}
+		box[i] = int(f)
+	}
+	return box, nil
+}
+
+// reuseNearDuplicate stores the image's perceptual hashes and, when an
+// earlier product has a near-identical image, copies its analysis instead
+// of calling the model. The earlier analysis is only reused if it has the
+// job's locales and meets the job's copy policy. It returns true if the
+// analysis was reused.
+func reuseNearDuplicate(productID int, img *image.Decoded, opts JobOptions, policy *copypolicy.Policy, locales []string, dbConn *db.Postgres, cfg NearDuplicateConfig) bool {
+	if !cfg.Enabled {
+		return false
+	}
+
+	hashes := image.Hash(img)
+
+	if err := dbConn.UpdateImageHashes(productID, hashes.DHash, hashes.PHash); err != nil {
+		log.Printf("DB Update Failed: %v\n", err)
+	}
+
+	if opts.Force {
+		return false
+	}
+
+	sourceID, err := dbConn.FindNearDuplicate(productID, hashes.DHash, hashes.PHash, cfg.MaxDistance)
+	if err != nil {
+		log.Printf("Near-duplicate lookup failed: %v\n", err)
+		return false
+	}
+	if sourceID == 0 {
+		return false
+	}
+
+	source, err := dbConn.Analysis(sourceID)
+	if err != nil {
+		log.Printf("Near-duplicate lookup failed: %v\n", err)
+		return false
+	}
+	if reason := reuseMismatch(source, policy, locales); reason != "" {
+		fmt.Printf("Near-duplicate of product %d found, not reusing its analysis: %s\n", sourceID, reason)
+		return false
+	}
+
+	fmt.Printf("Near-duplicate of product %d found, reusing its analysis\n", sourceID)
+	if err := dbConn.ReuseAnalysis(productID, sourceID); err != nil {
+		log.Printf("DB Update Failed: %v\n", err)
+		return false
+	}
+
+	fmt.Println("Success! Updated DB.")
+	return true
+}
+
+// reuseMismatch explains why an earlier analysis can't stand in for this
+// job's, or returns "" if it can
+func reuseMismatch(source map[string]interface{}, policy *copypolicy.Policy, locales []string) string {
+	translations, _ := source["translations"].(map[string]interface{})
+	if len(translations) != len(locales) {
+		return "it was translated into different locales"
+	}
+	for _, locale := range locales {
+		if _, ok := translations[locale]; !ok {
+			return "it has no " + locale + " translation"
+		}
+	}
+
+	if policy == nil {
+		return ""
+	}
+	title, _ := source["title"].(string)
+	policy.Apply(source)
+	if source["title"] != title {
+		return "its title doesn't follow the copy policy's casing"
+	}
+	if problems := policy.Validate(source); len(problems) > 0 {
+		return "it breaks the copy policy: " + strings.Join(problems, "; ")
+	}
+	return ""
+}
+
+// analyzeWithPolicy runs the analysis and checks the copy against the
+// merchant's policy, re-generating with feedback on violation. Violations
+// left after the last retry are recorded so the listing is blocked.
+func analyzeWithPolicy(aiEngine *ai.Engine, resultCache *cache.Results, images []*image.Decoded, analyzeOpts ai.AnalyzeOptions) (map[string]interface{}, error) {
+	policy := analyzeOpts.CopyPolicy
+
+	for attempt := 0; ; attempt++ {
+		jsonResult, err := analyzeCached(aiEngine, resultCache, images, analyzeOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Printf("AI Result (raw): %s\n", jsonResult)
+
+		// Clean and validate JSON
+		result, err := parseResult(jsonResult)
+		if err != nil {
+			return nil, fmt.Errorf("JSON parsing error: %v", err)
+		}
+
+		if policy == nil {
+			return result, nil
+		}
+
+		policy.Apply(result)
+		problems := policy.Validate(result)
+		if len(problems) == 0 {
+			return result, nil
+		}
+
+		if attempt >= policy.MaxRetries {
+			log.Printf("Copy policy still violated after %d retries: %s\n", attempt, strings.Join(problems, "; "))
+			policy.Truncate(result)
+			if remaining := policy.Validate(result); len(remaining) > 0 {
+				violations, _ := result["violations"].(map[string]interface{})
+				if violations == nil {
+					violations = make(map[string]interface{})
+				}
+				violations["copy_policy"] = map[string]interface{}{"reason": strings.Join(remaining, "; ")}
+				result["violations"] = violations
+			}
+			return result, nil
+		}
+
+		fmt.Printf("Copy policy violated, re-generating (attempt %d): %s\n", attempt+1, strings.Join(problems, "; "))
+		analyzeOpts.Feedback = "Your previous answer broke the copy rules: " + strings.Join(problems, "; ") + ". Fix these problems in your new answer."
+	}
+}
+
+// analyzeCached returns the cached model output for the same images and
+// provenance, or runs the model and caches its output
+func analyzeCached(aiEngine *ai.Engine, resultCache *cache.Results, images []*image.Decoded, analyzeOpts ai.AnalyzeOptions) (string, error) {
+	if resultCache == nil {
+		return aiEngine.AnalyzeImage(images, analyzeOpts)
+	}
+
+	provenance, err := aiEngine.Provenance(len(images), analyzeOpts)
+	if err != nil {
+		// Let the analysis report the error
+		return aiEngine.AnalyzeImage(images, analyzeOpts)
+	}
+	uploads := make([][]byte, 0, len(images))
+	for _, img := range images {
+		uploads = append(uploads, img.Data)
+	}
+	key := cache.Key(uploads, provenance)
+
+	if cached, ok := resultCache.Get(key); ok {
+		fmt.Println("Result cache hit, skipping inference")
+		return cached, nil
+	}
+
+	jsonResult, err := aiEngine.AnalyzeImage(images, analyzeOpts)
+	if err != nil {
+		return "", err
+	}
+
+	// Only cache output that parses, so a bad generation can be retried
+	if _, err := parseResult(jsonResult); err == nil {
+		resultCache.Set(key, jsonResult)
+	}
+	return jsonResult, nil
+}
+
+// parseImagePaths accepts either a single image path (the original job
+// format) or an array of paths for multi-image products. A path may also
+// be an http(s):// or s3:// URL.
+func parseImagePaths(arg interface{}) ([]string, error) {
+	switch v := arg.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		paths := make([]string, 0, len(v))
+		for i, item := range v {
+			path, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("image path %d is not a string", i)
+			}
+			paths = append(paths, path)
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no image paths provided")
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("unsupported image path argument type %T", arg)
+	}
+}
+
+func parseJobOptions(args []interface{}) (JobOptions, error) {
+	opts := JobOptions{Mode: "single"}
+	if len(args) < 3 || args[2] == nil {
+		return opts, nil
+	}
+
+	raw, err := json.Marshal(args[2])
+	if err != nil {
+		return opts, fmt.Errorf("failed to read job options: %w", err)
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, fmt.Errorf("invalid job options: %w", err)
+	}
+
+	switch opts.Mode {
+	case "":
+		opts.Mode = "single"
+	case "single", "multi":
+	default:
+		return opts, fmt.Errorf("unknown analysis mode %q", opts.Mode)
+	}
+
+	return opts, nil
+}
+
+// parseResult validates the model output and normalizes its fields
+func parseResult(input string) (map[string]interface{}, error) {
 	// Remove excessive whitespace
 	input = strings.TrimSpace(input)
 
-	// Parse JSON to validate and reformat
+	// Parse JSON to validate
 	var data map[string]interface{}
 	if err := json.Unmarshal([]byte(input), &data); err != nil {
-		return "", fmt.Errorf("invalid JSON: %w", err)
+		return nil, fmt.Errorf("invalid JSON: %w", err)
 	}
 
-	// Re-marshal to compact JSON
-	compact, err := json.Marshal(data)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	if violations, ok := data["violations"].(map[string]interface{}); ok {
+		data["violations"] = flaggedViolations(violations)
 	}
 
-	return string(compact), nil
+	normalizeSEO(data)
+
+	return data, nil
+}
+
+// flaggedViolations reduces the model's policy checks to only the flagged
+// ones, mapped to their reason, so a clean listing stores an empty object.
+func flaggedViolations(violations map[string]interface{}) map[string]interface{} {
+	flagged := make(map[string]interface{})
+	for policy, value := range violations {
+		check, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if isFlagged, _ := check["flagged"].(bool); !isFlagged {
+			continue
+		}
+		reason, _ := check["reason"].(string)
+		flagged[policy] = map[string]interface{}{"reason": strings.TrimSpace(reason)}
+	}
+	return flagged
 }
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/db"
+)
+
+type ActiveStorageConfig struct {
+	// Buckets maps S3 service names from storage.yml to their bucket.
+	// Blobs of any other service are read with the Disk service layout
+	// under the image roots.
+	Buckets map[string]string `yaml:"buckets"`
+}
+
+// blobSource resolves activestorage://<key> references through the
+// active_storage_blobs table, so jobs name an upload rather than a path,
+// and verifies the file against the checksum recorded on upload
+type blobSource struct {
+	dbConn   *db.Postgres
+	roots    *Roots
+	local    *localSource
+	s3       *s3Source
+	cfg      ActiveStorageConfig
+	maxBytes int64
+}
+
+func (s *blobSource) Fetch(ref string) ([]byte, error) {
+	key := strings.TrimPrefix(ref, "activestorage://")
+	if !validBlobKey(key) {
+		return nil, &SecurityError{Path: ref, Reason: "invalid blob key"}
+	}
+
+	blob, err := s.dbConn.FindBlob(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", key, err)
+	}
+	if blob == nil {
+		return nil, fmt.Errorf("blob %s not found", key)
+	}
+	if !strings.HasPrefix(blob.ContentType, "image/") {
+		return nil, fmt.Errorf("blob %s is not an image (%s)", key, blob.ContentType)
+	}
+	if s.maxBytes > 0 && blob.ByteSize > s.maxBytes {
+		return nil, fmt.Errorf("image file is too large (%d bytes, limit %d)", blob.ByteSize, s.maxBytes)
+	}
+
+	var data []byte
+	if bucket, ok := s.cfg.Buckets[blob.ServiceName]; ok {
+		if s.s3 == nil {
+			return nil, fmt.Errorf("blob %s is stored in S3 service %s, but S3 sources are not enabled", key, blob.ServiceName)
+		}
+		data, err = s.s3.Fetch("s3://" + bucket + "/" + key)
+	} else {
+		data, err = s.fetchDisk(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != blob.ByteSize {
+		return nil, fmt.Errorf("blob %s is %d bytes, expected %d", key, len(data), blob.ByteSize)
+	}
+	if err := verifyChecksum(data, blob.Checksum); err != nil {
+		return nil, fmt.Errorf("blob %s: %w", key, err)
+	}
+
+	fmt.Printf("Verified blob %s (%s, %d bytes)\n", key, blob.ContentType, blob.ByteSize)
+	return data, nil
+}
+
+// fetchDisk reads a blob from the Disk service layout,
+// <root>/<key[0:2]>/<key[2:4]>/<key>, trying each image root
+func (s *blobSource) fetchDisk(key string) ([]byte, error) {
+	for _, root := range s.roots.dirs {
+		path := filepath.Join(root, key[0:2], key[2:4], key)
+		if _, err := os.Stat(path); err == nil {
+			return s.local.Fetch(path)
+		}
+	}
+	return nil, fmt.Errorf("blob %s not found under the image roots", key)
+}
+
+// validBlobKey accepts ActiveStorage's generated keys (lowercase base36),
+// which also keeps the key from escaping the Disk layout
+func validBlobKey(key string) bool {
+	if len(key) < 4 {
+		return false
+	}
+	for _, c := range key {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyChecksum compares data with ActiveStorage's base64 checksum, an
+// MD5 digest by default or SHA-256 when configured
+func verifyChecksum(data []byte, checksum string) error {
+	expected, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || checksum == "" {
+		return fmt.Errorf("invalid checksum %q", checksum)
+	}
+
+	var actual []byte
+	switch len(expected) {
+	case md5.Size:
+		sum := md5.Sum(data)
+		actual = sum[:]
+	case sha256.Size:
+		sum := sha256.Sum256(data)
+		actual = sum[:]
+	default:
+		return fmt.Errorf("unsupported checksum %q", checksum)
+	}
+
+	if !bytes.Equal(actual, expected) {
+		return fmt.Errorf("checksum mismatch, the stored file differs from the upload")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHTTPFetchErrorHidesSignature(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens once the server is closed, so the request fails
+	server.Close()
+
+	s := newHTTPSource(HTTPConfig{AllowedHosts: []string{u.Host}}, 0)
+	_, err = s.Fetch(server.URL + "/blob.jpg?X-Amz-Signature=secret")
+	if err == nil {
+		t.Fatal("Fetch from a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the signature: %v", err)
+	}
+}
+
+func TestHTTPFetchRejectsRedirectToOtherHost(t *testing.T) {
+	server := httptest.NewServer(http.RedirectHandler("http://example.com/blob.jpg?signature=secret", http.StatusFound))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newHTTPSource(HTTPConfig{AllowedHosts: []string{u.Host}}, 0)
+	_, err = s.Fetch(server.URL + "/blob.jpg")
+	if _, ok := err.(*SecurityError); !ok {
+		t.Fatalf("Fetch = %v, want a SecurityError", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the signature: %v", err)
+	}
+}
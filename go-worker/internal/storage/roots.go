@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SecurityError is an image path outside the allowed roots. Jobs are
+// pushed to Redis by anyone who can reach it, so these are flagged rather
+// than treated as ordinary bad input.
+type SecurityError struct {
+	Path   string
+	Reason string
+}
+
+func (e *SecurityError) Error() string {
+	return fmt.Sprintf("image path %q rejected: %s", e.Path, e.Reason)
+}
+
+// Roots is the allow-list of directories job images may be read from,
+// both as configured and with symlinks resolved
+type Roots struct {
+	dirs []string
+}
+
+// NewRoots makes each root absolute and also allows its symlink-free
+// form. Roots that don't exist on this host are skipped, so one config can
+// list both the local and the Docker storage paths.
+func NewRoots(dirs []string) (*Roots, error) {
+	roots := &Roots{}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve image root %s: %w", dir, err)
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			fmt.Printf("Warning: image root %s is not available: %v\n", dir, err)
+			continue
+		}
+		roots.dirs = append(roots.dirs, abs)
+		if resolved != abs {
+			roots.dirs = append(roots.dirs, resolved)
+		}
+	}
+
+	if len(roots.dirs) == 0 {
+		fmt.Println("Warning: no image roots available, every job will be rejected")
+	}
+	return roots, nil
+}
+
+// Resolve returns the real path of a job's image, or a *SecurityError if
+// it climbs out of its directory, resolves outside every root or isn't a
+// regular file
+func (r *Roots) Resolve(path string) (string, error) {
+	if path == "" {
+		return "", &SecurityError{Path: path, Reason: "empty path"}
+	}
+	if !filepath.IsAbs(path) {
+		return "", &SecurityError{Path: path, Reason: "path is not absolute"}
+	}
+	if slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..") {
+		return "", &SecurityError{Path: path, Reason: "path traversal"}
+	}
+
+	// Checked before touching the filesystem, so errors can't reveal
+	// which files exist elsewhere
+	if !r.contains(filepath.Clean(path)) {
+		return "", &SecurityError{Path: path, Reason: "outside the allowed image roots"}
+	}
+
+	// Checked again with symlinks resolved, so a link inside a root can't
+	// point outside it
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("image not found: %s", path)
+		}
+		return "", fmt.Errorf("failed to resolve image path: %w", err)
+	}
+
+	if !r.contains(resolved) {
+		return "", &SecurityError{Path: path, Reason: "outside the allowed image roots"}
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat image: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", &SecurityError{Path: path, Reason: "not a regular file"}
+	}
+
+	return resolved, nil
+}
+
+func (r *Roots) contains(path string) bool {
+	for _, dir := range r.dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && rel != "." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootsResolve(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "storage")
+	sibling := filepath.Join(base, "storage2")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, filepath.Join(root, "ab"), sibling, outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	image := filepath.Join(root, "ab", "image.jpg")
+	for _, path := range []string{image, filepath.Join(sibling, "image.jpg"), filepath.Join(outside, "secret.jpg")} {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.jpg"), filepath.Join(root, "escape.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(image, filepath.Join(root, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	roots, err := NewRoots([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvedImage, err := filepath.EvalSymlinks(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		want     string
+		security bool
+	}{
+		{"file inside the root", image, resolvedImage, false},
+		{"symlink inside the root", filepath.Join(root, "link.jpg"), resolvedImage, false},
+		{"empty path", "", "", true},
+		{"relative path", "ab/image.jpg", "", true},
+		{"parent directory", filepath.Join(root, "ab") + "/../../outside/secret.jpg", "", true},
+		{"parent directory back into the root", root + "/ab/../ab/image.jpg", "", true},
+		{"symlink escaping the root", filepath.Join(root, "escape.jpg"), "", true},
+		{"root prefix", filepath.Join(sibling, "image.jpg"), "", true},
+		{"root itself", root, "", true},
+		{"directory", filepath.Join(root, "ab"), "", true},
+		{"outside every root", filepath.Join(outside, "secret.jpg"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roots.Resolve(tt.path)
+			var securityErr *SecurityError
+			if tt.security {
+				if !errors.As(err, &securityErr) {
+					t.Fatalf("Resolve(%q) = %q, %v; want a SecurityError", tt.path, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) failed: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootsResolveMissingFile(t *testing.T) {
+	root := t.TempDir()
+	roots, err := NewRoots([]string{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = roots.Resolve(filepath.Join(root, "missing.jpg"))
+	var securityErr *SecurityError
+	if err == nil || errors.As(err, &securityErr) {
+		t.Fatalf("Resolve of a missing file = %v, want a plain error", err)
+	}
+}
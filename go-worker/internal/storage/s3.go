@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3Config struct {
+	// Endpoint is the S3 API base URL, e.g. http://localhost:9000 for MinIO
+	Endpoint string `yaml:"endpoint"`
+	Region   string `yaml:"region"`
+	// PathStyle addresses objects as endpoint/bucket/key, as MinIO expects,
+	// instead of bucket.endpoint/key
+	PathStyle bool `yaml:"path_style"`
+	// Buckets images may be read from; empty disables S3 sources
+	Buckets []string `yaml:"buckets"`
+
+	// Credentials come from the AWS_* environment variables
+	AccessKeyID     string `yaml:"-"`
+	SecretAccessKey string `yaml:"-"`
+	SessionToken    string `yaml:"-"`
+}
+
+// s3Source downloads s3://bucket/key objects from an S3-compatible API
+// with Signature Version 4 signed requests
+type s3Source struct {
+	client   *http.Client
+	cfg      S3Config
+	maxBytes int64
+}
+
+func newS3Source(cfg S3Config, maxBytes int64) *s3Source {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &s3Source{
+		client:   &http.Client{Timeout: 60 * time.Second},
+		cfg:      cfg,
+		maxBytes: maxBytes,
+	}
+}
+
+func (s *s3Source) Fetch(ref string) ([]byte, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL: %w", err)
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", ref)
+	}
+	if !slices.Contains(s.cfg.Buckets, bucket) {
+		return nil, &SecurityError{Path: ref, Reason: "bucket is not allowed"}
+	}
+
+	objectURL, err := s.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", objectURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: HTTP %d", ref, resp.StatusCode)
+	}
+	if s.maxBytes > 0 && resp.ContentLength > s.maxBytes {
+		return nil, fmt.Errorf("image file is too large (%d bytes, limit %d)", resp.ContentLength, s.maxBytes)
+	}
+
+	return readLimited(resp.Body, s.maxBytes)
+}
+
+func (s *s3Source) objectURL(bucket string, key string) (*url.URL, error) {
+	endpoint, err := url.Parse(s.cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", s.cfg.Endpoint)
+	}
+
+	objectURL := *endpoint
+	path := "/" + key
+	if s.cfg.PathStyle {
+		path = "/" + bucket + "/" + key
+	} else {
+		objectURL.Host = bucket + "." + endpoint.Host
+	}
+	// S3 signs the URI-encoded path, so send exactly that encoding
+	objectURL.Path = path
+	objectURL.RawPath = uriEncode(path, false)
+	return &objectURL, nil
+}
+
+// sign adds Signature Version 4 headers, signing the host and every
+// header already set on the request
+func (s *s3Source) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.cfg.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as S3
+// signing requires; slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
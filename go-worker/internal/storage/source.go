@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rivanjarjes/image2taxonomy/worker/internal/db"
+)
+
+// Source fetches a job's image into memory
+type Source interface {
+	Fetch(ref string) ([]byte, error)
+}
+
+// Config enables the remote sources; local paths and ActiveStorage blobs
+// are always available
+type Config struct {
+	HTTP          HTTPConfig          `yaml:"http"`
+	S3            S3Config            `yaml:"s3"`
+	ActiveStorage ActiveStorageConfig `yaml:"active_storage"`
+}
+
+// Sources picks the source for an image reference by its scheme: local
+// paths (bare or file://), http(s):// URLs, s3://bucket/key objects and
+// activestorage://<key> blobs
+type Sources struct {
+	schemes map[string]Source
+}
+
+// NewSources builds the sources, each refusing images over maxBytes
+func NewSources(roots *Roots, cfg Config, maxBytes int64, dbConn *db.Postgres) *Sources {
+	local := &localSource{roots: roots, maxBytes: maxBytes}
+	schemes := map[string]Source{
+		"":     local,
+		"file": local,
+	}
+
+	if len(cfg.HTTP.AllowedHosts) > 0 {
+		source := newHTTPSource(cfg.HTTP, maxBytes)
+		schemes["http"] = source
+		schemes["https"] = source
+	}
+
+	var s3 *s3Source
+	if len(cfg.S3.Buckets) > 0 {
+		s3 = newS3Source(cfg.S3, maxBytes)
+		schemes["s3"] = s3
+	}
+
+	schemes["activestorage"] = &blobSource{
+		dbConn:   dbConn,
+		roots:    roots,
+		local:    local,
+		s3:       s3,
+		cfg:      cfg.ActiveStorage,
+		maxBytes: maxBytes,
+	}
+
+	return &Sources{schemes: schemes}
+}
+
+func (s *Sources) Fetch(ref string) ([]byte, error) {
+	scheme := ""
+	if i := strings.Index(ref, "://"); i > 0 {
+		scheme = strings.ToLower(ref[:i])
+	}
+
+	source, ok := s.schemes[scheme]
+	if !ok {
+		return nil, fmt.Errorf("image source %q is not enabled", scheme)
+	}
+	return source.Fetch(ref)
+}
+
+// localSource reads files inside the storage roots
+type localSource struct {
+	roots    *Roots
+	maxBytes int64
+}
+
+func (s *localSource) Fetch(ref string) ([]byte, error) {
+	path := ref
+	if strings.HasPrefix(ref, "file://") {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file URL: %w", err)
+		}
+		path = u.Path
+	}
+
+	resolved, err := s.roots.Resolve(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat image: %w", err)
+	}
+	if s.maxBytes > 0 && info.Size() > s.maxBytes {
+		return nil, fmt.Errorf("image file is too large (%d bytes, limit %d)", info.Size(), s.maxBytes)
+	}
+
+	return readLimited(f, s.maxBytes)
+}
+
+// readLimited reads r to the end, failing once it passes maxBytes (zero
+// means no limit) rather than buffering an unbounded body
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("image file is too large (over %d bytes)", maxBytes)
+	}
+	return data, nil
+}
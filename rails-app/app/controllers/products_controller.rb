@@ -16,6 +16,13 @@ class ProductsController < ApplicationController
     end
   end
 
+  def crop
+    crop = Product.find(params[:id]).crop
+    return head :not_found unless crop
+
+    send_data crop.data, type: crop.content_type, disposition: :inline
+  end
+
   def new
     @product = Product.new
   end
@@ -23,11 +30,8 @@ class ProductsController < ApplicationController
   def create
     @product = Product.new(product_params)
     if @product.save
-      # Get the local file path for the uploaded image
-      image_path = ActiveStorage::Blob.service.path_for(@product.image.key)
-      
-      # Enqueue job with both product_id and image_path for Go worker
-      ProductAnalysisJob.perform_async(@product.id, image_path)
+      # Enqueue job with both product_id and image reference for Go worker
+      ProductAnalysisJob.perform_async(@product.id, image_reference(@product.image))
       redirect_to @product, notice: "Product uploaded and analysis started"
     else
       render :new, status: :unprocessable_entity
@@ -36,6 +40,17 @@ class ProductsController < ApplicationController
 
   private
 
+  # The Go worker resolves blob keys of Disk and S3 services (e.g. MinIO)
+  # itself and verifies the checksum; anything else gets a signed URL
+  def image_reference(image)
+    service = image.blob.service
+    if service.respond_to?(:path_for) || service.respond_to?(:bucket)
+      "activestorage://#{image.key}"
+    else
+      rails_blob_url(image)
+    end
+  end
+
   def product_params
     params.require(:product).permit(:title, :image)
   end
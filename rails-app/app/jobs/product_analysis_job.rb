@@ -1,9 +1,18 @@
 class ProductAnalysisJob
   include Sidekiq::Job
 
-  def perform(product_id, image_path)
+  def perform(product_id, image_paths, options = {})
     # This job is processed by the Go worker
     # The Go worker reads from the same Redis queue and handles the actual AI processing
-    # Rails just enqueues the job with product_id and image_path
+    # Rails just enqueues the job with product_id and image_paths
+    # image_paths may be a single path or an array of paths (front/back/detail shots of one product)
+    # each path may be a local file path, an http(s):// URL, an s3://bucket/key object
+    # or an activestorage://<blob key> the worker resolves and checksum-verifies
+    # options is optional, e.g. { "mode" => "multi" } to detect every product in the image (stored in products.items)
+    # or { "locales" => ["fr"] } to override the output locales from config.yml (stored in products.translations)
+    # or { "merchant" => "acme" } to apply that merchant's copy policy from config.yml
+    # or { "force" => true } to re-run the model instead of reusing a near-duplicate or cached result
+    # or { "trim" => false } to keep the photo's borders instead of cropping to the product
+    # or { "tiles" => true } to also send high-resolution tiles of the image for small labels and logos
   end
 end
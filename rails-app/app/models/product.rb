@@ -1,11 +1,23 @@
 class Product < ApplicationRecord
   has_one_attached :image
 
+  # Set by the Go worker when the analysis was copied from a near-duplicate image
+  belongs_to :reused_from, class_name: "Product", optional: true
+
+  # Crop of the main product cut out by the Go worker from its bounding box
+  has_one :crop, class_name: "ProductCrop", dependent: :delete
+
   enum :processing_status, { pending: "pending", processing: "processing", complete: "complete", failed: "failed" }
 
   validates :image, presence: true
   before_create :set_default_title
 
+  # The Go worker only stores flagged policy checks, keyed by policy
+  # (e.g. "weapons" => { "reason" => "..." }), so any entry blocks the listing
+  def blocked?
+    violations.present?
+  end
+
   private
 
   def set_default_title
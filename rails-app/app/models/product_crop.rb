@@ -0,0 +1,3 @@
+class ProductCrop < ApplicationRecord
+  belongs_to :product
+end
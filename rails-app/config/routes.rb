@@ -11,6 +11,8 @@ Rails.application.routes.draw do
 
   # Defines the root path route ("/")
   # root "posts#index"
-  resources :products
+  resources :products do
+    get :crop, on: :member
+  end
   root "products#new"
 end
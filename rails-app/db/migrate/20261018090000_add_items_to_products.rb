@@ -0,0 +1,5 @@
+class AddItemsToProducts < ActiveRecord::Migration[8.1]
+  def change
+    add_column :products, :items, :jsonb, default: []
+  end
+end
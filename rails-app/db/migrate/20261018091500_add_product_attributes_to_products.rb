@@ -0,0 +1,6 @@
+class AddProductAttributesToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Named product_attributes because "attributes" is reserved by Active Record
+    add_column :products, :product_attributes, :jsonb, default: {}
+  end
+end
@@ -0,0 +1,8 @@
+class AddSeoFieldsToProducts < ActiveRecord::Migration[8.1]
+  def change
+    add_column :products, :tags, :jsonb, default: []
+    add_column :products, :alt_text, :text
+    add_column :products, :meta_description, :string
+    add_column :products, :handle, :string
+  end
+end
@@ -0,0 +1,6 @@
+class AddTranslationsToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Per-locale title, description and taxonomy, e.g. { "fr" => { "title" => ... } }
+    add_column :products, :translations, :jsonb, default: {}
+  end
+end
@@ -0,0 +1,9 @@
+class AddImageHashesToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # 64-bit perceptual hashes written by the Go worker for near-duplicate detection
+    add_column :products, :image_dhash, :bigint
+    add_column :products, :image_phash, :bigint
+    add_column :products, :analysis_reused, :boolean, default: false, null: false
+    add_reference :products, :reused_from, foreign_key: { to_table: :products, on_delete: :nullify }
+  end
+end
@@ -0,0 +1,6 @@
+class AddImageQualityToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Per-image quality scores (sharpness, contrast, brightness) written by the Go worker
+    add_column :products, :image_quality, :jsonb, default: []
+  end
+end
@@ -0,0 +1,6 @@
+class AddSecurityFlaggedToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Set by the Go worker when a job named an image outside the allowed storage roots
+    add_column :products, :security_flagged, :boolean, default: false, null: false
+  end
+end
@@ -0,0 +1,6 @@
+class AddDominantColorsToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Dominant colors of the main product ({hex, name, share}) measured by the Go worker
+    add_column :products, :dominant_colors, :jsonb, default: []
+  end
+end
@@ -0,0 +1,14 @@
+class AddBboxAndCropsToProducts < ActiveRecord::Migration[8.1]
+  def change
+    # Bounding box [x1, y1, x2, y2] of the main product, in 0-1000 coordinates of the analyzed image
+    add_column :products, :bbox, :jsonb
+
+    # Thumbnail of the main product cut out by the Go worker from the bounding box
+    create_table :product_crops do |t|
+      t.references :product, null: false, index: { unique: true }, foreign_key: { on_delete: :cascade }
+      t.binary :data, null: false
+      t.string :content_type, null: false
+      t.timestamps
+    end
+  end
+end
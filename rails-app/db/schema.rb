@@ -10,7 +10,7 @@
 #
 # It's strongly recommended that you check this file into your version control system.
 
-ActiveRecord::Schema[8.1].define(version: 2025_12_01_073831) do
+ActiveRecord::Schema[8.1].define(version: 2026_10_18_110000) do
   # These are extensions that must be enabled in order to support this database
   enable_extension "pg_catalog.plpgsql"
 
@@ -42,17 +42,44 @@ ActiveRecord::Schema[8.1].define(version: 2025_12_01_073831) do
     t.index ["blob_id", "variation_digest"], name: "index_active_storage_variant_records_uniqueness", unique: true
   end
 
+  create_table "product_crops", force: :cascade do |t|
+    t.datetime "created_at", null: false
+    t.string "content_type", null: false
+    t.binary "data", null: false
+    t.bigint "product_id", null: false
+    t.datetime "updated_at", null: false
+    t.index ["product_id"], name: "index_product_crops_on_product_id", unique: true
+  end
+
   create_table "products", force: :cascade do |t|
+    t.text "alt_text"
+    t.boolean "analysis_reused", default: false, null: false
+    t.jsonb "bbox"
     t.datetime "created_at", null: false
     t.text "description"
+    t.jsonb "dominant_colors", default: []
     t.text "error_message"
+    t.string "handle"
+    t.bigint "image_dhash"
+    t.bigint "image_phash"
+    t.jsonb "image_quality", default: []
+    t.jsonb "items", default: []
+    t.string "meta_description"
     t.string "processing_status", default: "pending"
+    t.jsonb "product_attributes", default: {}
+    t.bigint "reused_from_id"
+    t.boolean "security_flagged", default: false, null: false
+    t.jsonb "tags", default: []
     t.string "taxonomy"
     t.string "title"
+    t.jsonb "translations", default: {}
     t.datetime "updated_at", null: false
     t.jsonb "violations", default: {}
+    t.index ["reused_from_id"], name: "index_products_on_reused_from_id"
   end
 
   add_foreign_key "active_storage_attachments", "active_storage_blobs", column: "blob_id"
   add_foreign_key "active_storage_variant_records", "active_storage_blobs", column: "blob_id"
+  add_foreign_key "product_crops", "products", on_delete: :cascade
+  add_foreign_key "products", "products", column: "reused_from_id", on_delete: :nullify
 end